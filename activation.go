@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// With systemd socket activation, systemd listens on the HTTP port and the control socket and starts the daemon
+// on the first connection, passing both listeners as file descriptors starting at 3. LISTEN_FDS holds their
+// number and LISTEN_FDNAMES their names, as set with FileDescriptorName= in the socket units.
+
+const (
+	// listenFDsStart is the first file descriptor passed by systemd
+	listenFDsStart = 3
+
+	// Names of the passed listeners in LISTEN_FDNAMES. Listeners with other names are told apart by their type.
+	activationNameHTTP    = "http"
+	activationNameControl = "control"
+)
+
+var (
+	// activatedHTTP is the HTTP listener passed by systemd, nil without socket activation
+	activatedHTTP net.Listener
+
+	// activatedControl is the control socket listener passed by systemd, nil without socket activation
+	activatedControl net.Listener
+)
+
+// socketActivated reports whether systemd passed listeners to this process
+func socketActivated() bool {
+	return os.Getenv("LISTEN_FDS") != "" && os.Getenv("LISTEN_PID") == strconv.Itoa(os.Getpid())
+}
+
+// inheritActivatedListeners picks up the listeners passed by systemd socket activation, if any
+func inheritActivatedListeners() error {
+	if !socketActivated() {
+		return nil
+	}
+
+	value := os.Getenv("LISTEN_FDS")
+	count, err := strconv.Atoi(value)
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	// Processes started by the daemon must not inherit the variables
+	for _, name := range []string{"LISTEN_FDS", "LISTEN_PID", "LISTEN_FDNAMES"} {
+		_ = os.Unsetenv(name)
+	}
+
+	if err != nil || count < 0 {
+		return fmt.Errorf("invalid LISTEN_FDS: %s", value)
+	}
+
+	for i := range count {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+
+		listener, err := activatedListener(listenFDsStart + i)
+		if err != nil {
+			return fmt.Errorf("failed to use socket %q passed by systemd: %w", name, err)
+		}
+
+		_, isUnix := listener.(*net.UnixListener)
+		switch {
+		case (name == activationNameControl || (name != activationNameHTTP && isUnix)) && activatedControl == nil:
+			activatedControl = listener
+		case (name == activationNameHTTP || !isUnix) && activatedHTTP == nil:
+			activatedHTTP = listener
+		default:
+			slog.Warn("Ignoring extra socket passed by systemd", "name", name, "addr", listener.Addr())
+			_ = listener.Close()
+			continue
+		}
+		slog.Info("Using socket passed by systemd", "name", name, "addr", listener.Addr())
+	}
+
+	return nil
+}
+
+// activatedListener turns the inherited file descriptor fd into a listener
+func activatedListener(fd int) (net.Listener, error) {
+	file := os.NewFile(uintptr(fd), fmt.Sprintf("listen-fd-%d", fd))
+	defer func() { _ = file.Close() }()
+
+	return net.FileListener(file)
+}
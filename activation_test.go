@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+// setActivationEnv sets the socket activation variables for the duration of the test
+func setActivationEnv(t *testing.T, fds, pid string) {
+	for name, value := range map[string]string{"LISTEN_FDS": fds, "LISTEN_PID": pid} {
+		if err := os.Setenv(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		for _, name := range []string{"LISTEN_FDS", "LISTEN_PID", "LISTEN_FDNAMES"} {
+			if err := os.Unsetenv(name); err != nil {
+				t.Logf("Failed to unset %s: %v", name, err)
+			}
+		}
+	})
+}
+
+func TestSocketActivated(t *testing.T) {
+	t.Run("NotActivated", func(t *testing.T) {
+		if socketActivated() {
+			t.Error("Expected no socket activation without LISTEN_FDS")
+		}
+		if err := inheritActivatedListeners(); err != nil {
+			t.Errorf("Expected no error without socket activation, got %v", err)
+		}
+	})
+
+	t.Run("OtherProcess", func(t *testing.T) {
+		// The variables were meant for the process that started us
+		setActivationEnv(t, "2", strconv.Itoa(os.Getppid()))
+
+		if socketActivated() {
+			t.Error("Expected sockets passed to another process to be ignored")
+		}
+	})
+
+	t.Run("InvalidCount", func(t *testing.T) {
+		setActivationEnv(t, "many", strconv.Itoa(os.Getpid()))
+
+		if !socketActivated() {
+			t.Fatal("Expected socket activation to be detected")
+		}
+		if err := inheritActivatedListeners(); err == nil {
+			t.Error("Expected error for invalid LISTEN_FDS")
+		}
+		if os.Getenv("LISTEN_FDS") != "" {
+			t.Error("Expected LISTEN_FDS to be unset after use")
+		}
+	})
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// The HTTP API lets tools that cannot use the control socket, such as browser extensions and editor webviews,
+// manage the tracked files. Every request must carry the token that the server writes to api-token next to its
+// control socket, readable only by the user running it, in an "Authorization: Bearer <token>" header.
+// Requests are accepted from any origin: pages cannot read the token, and no cookie authorizes an API request.
+
+// maxAPIRequestSize limits the size of API request bodies
+const maxAPIRequestSize = 1 << 20
+
+// errCodeUnauthorized is returned for API requests without a valid token
+const errCodeUnauthorized = "unauthorized"
+
+var (
+	// apiToken is the token API requests are authorized with, empty until it has been written
+	apiToken     string
+	apiTokenLock sync.RWMutex
+)
+
+// apiFileRequest is the body of requests that add or remove a file
+type apiFileRequest struct {
+	Path string `json:"path"`
+	directoryFilter
+}
+
+// apiFile is the response to a request for a single file
+type apiFile struct {
+	trackedFileInfo
+	HTML string `json:"html"`
+}
+
+// apiErrorResponse is the body of a failed API request
+type apiErrorResponse struct {
+	Error *controlError `json:"error"`
+}
+
+// apiTokenPathFor returns the path of the API token file belonging to the control socket at socketPath
+func apiTokenPathFor(socketPath string) string {
+	return filepath.Join(filepath.Dir(socketPath), "api-token")
+}
+
+// writeAPIToken generates a new API token for this server and writes it next to the control socket at socketPath
+func writeAPIToken(socketPath string) error {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return fmt.Errorf("failed to generate API token: %w", err)
+	}
+	token := hex.EncodeToString(random)
+
+	if err := os.WriteFile(apiTokenPathFor(socketPath), []byte(token+"\n"), 0o600); err != nil {
+		return fmt.Errorf("failed to write API token: %w", err)
+	}
+
+	apiTokenLock.Lock()
+	apiToken = token
+	apiTokenLock.Unlock()
+
+	return nil
+}
+
+// removeAPIToken removes the API token file next to the control socket at socketPath
+func removeAPIToken(socketPath string) {
+	if err := os.Remove(apiTokenPathFor(socketPath)); err != nil && !os.IsNotExist(err) {
+		slog.Error("Failed to remove API token", "error", err)
+	}
+}
+
+// apiHandler returns the handler serving the HTTP API below /api/
+func apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/files", handleAPIListFiles)
+	mux.HandleFunc("POST /api/files", handleAPIAddFile)
+	mux.HandleFunc("DELETE /api/files", handleAPIRemoveFile)
+	mux.HandleFunc("GET /api/files/{id}", handleAPIGetFile)
+	mux.HandleFunc("DELETE /api/files/{id}", handleAPIRemoveFile)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		// Browsers ask before sending the Authorization header to another origin, without the header
+		if isAPIPreflight(r) {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if !authorizedAPIRequest(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="lum"`)
+			writeAPIError(w, &controlError{Code: errCodeUnauthorized, Message: "missing or invalid API token"})
+			return
+		}
+		recordActivity()
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// isAPIPreflight reports whether the request is a CORS preflight request for the API
+func isAPIPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && strings.HasPrefix(r.URL.Path, "/api/") &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// authorizedAPIRequest reports whether the request carries the API token
+func authorizedAPIRequest(r *http.Request) bool {
+	apiTokenLock.RLock()
+	token := apiToken
+	apiTokenLock.RUnlock()
+
+	given, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return found && token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// handleAPIListFiles lists the tracked files
+func handleAPIListFiles(w http.ResponseWriter, r *http.Request) {
+	writeAPIResponse(w, http.StatusOK, listResult{Files: listFiles(serverPort())})
+}
+
+// handleAPIAddFile adds the file or directory given in the request body
+func handleAPIAddFile(w http.ResponseWriter, r *http.Request) {
+	req, cerr := decodeAPIFileRequest(w, r)
+	if cerr != nil {
+		writeAPIError(w, cerr)
+		return
+	}
+
+	url, cerr := addTrackedFile(req.Path, req.directoryFilter)
+	if cerr != nil {
+		writeAPIError(w, cerr)
+		return
+	}
+	writeAPIResponse(w, http.StatusCreated, addResult{URL: url})
+}
+
+// handleAPIRemoveFile removes the file or directory given by the path's ID or in the request body
+func handleAPIRemoveFile(w http.ResponseWriter, r *http.Request) {
+	path := r.PathValue("id")
+	if path == "" {
+		req, cerr := decodeAPIFileRequest(w, r)
+		if cerr != nil {
+			writeAPIError(w, cerr)
+			return
+		}
+		path = req.Path
+	}
+
+	if cerr := removeTrackedFile(path); cerr != nil {
+		writeAPIError(w, cerr)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPIGetFile returns information about a tracked file together with its rendered HTML. The ID is the
+// file's path (or the ID of a document read from stdin), escaped as a single path segment.
+func handleAPIGetFile(w http.ResponseWriter, r *http.Request) {
+	path := r.PathValue("id")
+
+	port := serverPort()
+	filesLock.RLock()
+	fileState, exists := files[path]
+	var file apiFile
+	if exists {
+		file.trackedFileInfo = describeFile(port, path, fileState)
+	}
+	filesLock.RUnlock()
+
+	if !exists {
+		writeAPIError(w, &controlError{Code: errCodeNotTracked, Message: fmt.Sprintf("file not tracked: %s", path)})
+		return
+	}
+
+	fileState.contentLock.RLock()
+	file.HTML = string(fileState.htmlContent)
+	fileState.contentLock.RUnlock()
+
+	writeAPIResponse(w, http.StatusOK, file)
+}
+
+// decodeAPIFileRequest decodes and validates the body of a request that adds or removes a file
+func decodeAPIFileRequest(w http.ResponseWriter, r *http.Request) (apiFileRequest, *controlError) {
+	var req apiFileRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIRequestSize)).Decode(&req); err != nil {
+		return req, &controlError{Code: errCodeInvalidRequest, Message: fmt.Sprintf("invalid request body: %v", err)}
+	}
+	if req.Path == "" {
+		return req, &controlError{Code: errCodeInvalidRequest, Message: "missing 'path' field"}
+	}
+	// The server's working directory means nothing to API clients
+	if !filepath.IsAbs(req.Path) && !isStdinID(req.Path) {
+		message := fmt.Sprintf("path must be absolute: %s", req.Path)
+		return req, &controlError{Code: errCodeInvalidRequest, Message: message}
+	}
+
+	return req, nil
+}
+
+// writeAPIResponse writes a JSON response with the given status code
+func writeAPIResponse(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Error("Failed to write API response", "error", err)
+	}
+}
+
+// writeAPIError writes a failed API response, with the status code matching the error code
+func writeAPIError(w http.ResponseWriter, cerr *controlError) {
+	status := http.StatusInternalServerError
+	switch cerr.Code {
+	case errCodeInvalidRequest:
+		status = http.StatusBadRequest
+	case errCodeUnauthorized:
+		status = http.StatusUnauthorized
+	case errCodeFileNotFound, errCodeNotTracked:
+		status = http.StatusNotFound
+	}
+
+	writeAPIResponse(w, status, apiErrorResponse{Error: cerr})
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAPI(t *testing.T) {
+	tmpRuntimeDir := t.TempDir()
+	oldXDG := os.Getenv("XDG_RUNTIME_DIR")
+	if err := os.Setenv("XDG_RUNTIME_DIR", tmpRuntimeDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if oldXDG != "" {
+			_ = os.Setenv("XDG_RUNTIME_DIR", oldXDG)
+		} else {
+			_ = os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+	})
+
+	socketPath, err := getSocketPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeAPIToken(socketPath); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		removeAPIToken(socketPath)
+	})
+
+	tokenData, err := os.ReadFile(apiTokenPathFor(socketPath))
+	if err != nil {
+		t.Fatalf("Expected the token to be written: %v", err)
+	}
+	token := strings.TrimSpace(string(tokenData))
+	info, err := os.Stat(apiTokenPathFor(socketPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected the token to be readable by the owner only, got %v", info.Mode())
+	}
+
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.md")
+	if err := os.WriteFile(testFile, []byte("# API Test"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = removeFile(testFile)
+	})
+
+	server := httptest.NewServer(apiHandler())
+	defer server.Close()
+
+	// request sends an API request with the token and decodes the JSON response into result
+	request := func(t *testing.T, method, path, body string, result any) int {
+		t.Helper()
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != nil && len(data) > 0 {
+			if err := json.Unmarshal(data, result); err != nil {
+				t.Fatalf("Response is not valid JSON: %v\n%s", err, data)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		for _, header := range []string{"", "Bearer wrong", token} {
+			req, err := http.NewRequest("GET", server.URL+"/api/files", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header != "" {
+				req.Header.Set("Authorization", header)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("Expected status 401 for %q, got %d", header, resp.StatusCode)
+			}
+			if origin := resp.Header.Get("Access-Control-Allow-Origin"); origin != "*" {
+				t.Errorf("Expected failed requests to be readable from any origin, got %q", origin)
+			}
+		}
+	})
+
+	t.Run("Preflight", func(t *testing.T) {
+		req, err := http.NewRequest("OPTIONS", server.URL+"/api/files", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Origin", "vscode-webview://abc")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("Expected preflight requests to be answered without a token, got %d", resp.StatusCode)
+		}
+		if origin := resp.Header.Get("Access-Control-Allow-Origin"); origin != "*" {
+			t.Errorf("Expected any origin to be allowed, got %q", origin)
+		}
+		if headers := resp.Header.Get("Access-Control-Allow-Headers"); !strings.Contains(headers, "Authorization") {
+			t.Errorf("Expected the Authorization header to be allowed, got %q", headers)
+		}
+	})
+
+	t.Run("AddFile", func(t *testing.T) {
+		var added addResult
+		body := fmt.Sprintf(`{"path":%q}`, testFile)
+		if status := request(t, "POST", "/api/files", body, &added); status != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d", status)
+		}
+		if !strings.HasSuffix(added.URL, "?file="+testFile) {
+			t.Errorf("Expected the file's URL, got %s", added.URL)
+		}
+	})
+
+	t.Run("InvalidAdd", func(t *testing.T) {
+		tests := []struct {
+			body   string
+			status int
+			code   string
+		}{
+			{`{`, http.StatusBadRequest, errCodeInvalidRequest},
+			{`{"path":"relative.md"}`, http.StatusBadRequest, errCodeInvalidRequest},
+			{`{"path":"/nonexistent/file.md"}`, http.StatusNotFound, errCodeFileNotFound},
+		}
+
+		for _, tt := range tests {
+			var failed apiErrorResponse
+			status := request(t, "POST", "/api/files", tt.body, &failed)
+			if status != tt.status || failed.Error == nil || failed.Error.Code != tt.code {
+				t.Errorf("%s: expected %d with %s, got %d with %+v", tt.body, tt.status, tt.code, status, failed.Error)
+			}
+		}
+	})
+
+	t.Run("ListFiles", func(t *testing.T) {
+		var listed listResult
+		if status := request(t, "GET", "/api/files", "", &listed); status != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", status)
+		}
+		found := false
+		for _, info := range listed.Files {
+			if info.Path == testFile {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected %s in list, got %+v", testFile, listed.Files)
+		}
+	})
+
+	t.Run("GetFile", func(t *testing.T) {
+		var file apiFile
+		status := request(t, "GET", "/api/files/"+url.PathEscape(testFile), "", &file)
+		if status != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", status)
+		}
+		if file.Path != testFile || file.Status != "ok" || !strings.Contains(file.HTML, "API Test") {
+			t.Errorf("Expected the file's metadata and rendered HTML, got %+v", file)
+		}
+
+		var failed apiErrorResponse
+		status = request(t, "GET", "/api/files/"+url.PathEscape("/nonexistent/file.md"), "", &failed)
+		if status != http.StatusNotFound || failed.Error == nil || failed.Error.Code != errCodeNotTracked {
+			t.Errorf("Expected 404 with %s, got %d with %+v", errCodeNotTracked, status, failed.Error)
+		}
+	})
+
+	t.Run("RemoveFile", func(t *testing.T) {
+		status := request(t, "DELETE", "/api/files/"+url.PathEscape(testFile), "", nil)
+		if status != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d", status)
+		}
+
+		var failed apiErrorResponse
+		body := fmt.Sprintf(`{"path":%q}`, testFile)
+		if status := request(t, "DELETE", "/api/files", body, &failed); status != http.StatusNotFound {
+			t.Errorf("Expected status 404 for a file that is no longer tracked, got %d", status)
+		}
+	})
+}
@@ -1,9 +1,28 @@
 const evtSource = new EventSource('/events?file=' + encodeURIComponent(filePath));
+var serverStopped = false;
 evtSource.onmessage = function (event) {
     if (event.data === 'reload') {
         location.reload();
+    } else if (event.data === 'removed') {
+        evtSource.close();
+        showBanner('This file is no longer being served by lum.');
+    } else if (event.data === 'server-stopping') {
+        serverStopped = true;
+        showBanner('The lum server has stopped. This page will refresh when it is back.');
     }
 };
+evtSource.onopen = function () {
+    // The EventSource keeps retrying after the server went away, so reconnecting means it is back
+    if (serverStopped) {
+        location.reload();
+    }
+};
+
+function showBanner(message) {
+    var banner = document.querySelector('.banner');
+    banner.textContent = message;
+    banner.hidden = false;
+}
 
 (function () {
     var container = document.querySelector('.container');
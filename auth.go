@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// A server bound to a non-loopback address requires every HTTP request to be authorized with an access token or
+// basic-auth credentials. Browsers open a URL with ?token=TOKEN once and are given the token as a cookie, other
+// clients send it as "Authorization: Bearer TOKEN".
+
+const (
+	// tokenEnv and authEnv hold the access token and basic-auth credentials, as an alternative to --token and
+	// --auth that keeps them out of the process list. They also pass them on to a daemonized child.
+	tokenEnv = "LUM_TOKEN"
+	authEnv  = "LUM_AUTH"
+	// tokenCookie is the cookie that browsers keep the access token in
+	tokenCookie = "lum_token"
+)
+
+// accessCredentials are the credentials HTTP requests are authorized with
+type accessCredentials struct {
+	token    string
+	user     string
+	password string
+}
+
+var (
+	// credentials protect the running server's HTTP requests, no authorization is required while empty
+	credentials     accessCredentials
+	credentialsLock sync.RWMutex
+)
+
+// newAccessCredentials returns the credentials for an access token and basic-auth credentials given as
+// USER:PASSWORD, either of which may be empty
+func newAccessCredentials(token, auth string) (accessCredentials, error) {
+	creds := accessCredentials{token: token}
+	if auth == "" {
+		return creds, nil
+	}
+
+	user, password, found := strings.Cut(auth, ":")
+	if !found || user == "" || password == "" {
+		return creds, fmt.Errorf("invalid credentials: expected USER:PASSWORD")
+	}
+	creds.user = user
+	creds.password = password
+
+	return creds, nil
+}
+
+// setCredentials sets the credentials HTTP requests to the running server are authorized with
+func setCredentials(creds accessCredentials) {
+	credentialsLock.Lock()
+	credentials = creds
+	credentialsLock.Unlock()
+}
+
+// currentCredentials returns the credentials HTTP requests to the running server are authorized with
+func currentCredentials() accessCredentials {
+	credentialsLock.RLock()
+	defer credentialsLock.RUnlock()
+	return credentials
+}
+
+// empty reports whether no credentials are set
+func (c accessCredentials) empty() bool {
+	return c.token == "" && c.user == ""
+}
+
+// basicAuth returns the basic-auth credentials as USER:PASSWORD, empty if there are none
+func (c accessCredentials) basicAuth() string {
+	if c.user == "" {
+		return ""
+	}
+	return c.user + ":" + c.password
+}
+
+// validToken reports whether token is the access token
+func (c accessCredentials) validToken(token string) bool {
+	return c.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(c.token)) == 1
+}
+
+// authorized reports whether the request carries the access token, the basic-auth credentials or the API token
+func (c accessCredentials) authorized(r *http.Request) bool {
+	if cookie, err := r.Cookie(tokenCookie); err == nil && c.validToken(cookie.Value) {
+		return true
+	}
+	if bearer, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+		return c.validToken(bearer) || authorizedAPIRequest(r)
+	}
+	if user, password, ok := r.BasicAuth(); ok && c.user != "" {
+		userMatches := subtle.ConstantTimeCompare([]byte(user), []byte(c.user)) == 1
+		passwordMatches := subtle.ConstantTimeCompare([]byte(password), []byte(c.password)) == 1
+		return userMatches && passwordMatches
+	}
+
+	return false
+}
+
+// withAuth requires requests to be authorized while credentials are set
+func withAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		creds := currentCredentials()
+		// Preflight requests carry no credentials, the API answers them without serving anything
+		if creds.empty() || creds.authorized(r) || isAPIPreflight(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Keep the token of a URL opened in a browser in a cookie, and drop it from the address bar
+		query := r.URL.Query()
+		if creds.validToken(query.Get("token")) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     tokenCookie,
+				Value:    creds.token,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+			query.Del("token")
+			target := *r.URL
+			target.RawQuery = query.Encode()
+			http.Redirect(w, r, target.RequestURI(), http.StatusSeeOther)
+			return
+		}
+
+		if creds.user != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="lum", charset="UTF-8"`)
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewAccessCredentials(t *testing.T) {
+	creds, err := newAccessCredentials("s3cret", "alice:pass:word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.token != "s3cret" || creds.user != "alice" || creds.password != "pass:word" {
+		t.Errorf("Unexpected credentials: %+v", creds)
+	}
+	if creds.basicAuth() != "alice:pass:word" {
+		t.Errorf("Expected the credentials to round-trip, got %q", creds.basicAuth())
+	}
+
+	for _, auth := range []string{"alice", ":password", "alice:"} {
+		if _, err := newAccessCredentials("", auth); err == nil {
+			t.Errorf("Expected error for %q", auth)
+		}
+	}
+}
+
+func TestWithAuth(t *testing.T) {
+	handler := withAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(func() {
+		setCredentials(accessCredentials{})
+	})
+
+	// serve sends the request through withAuth
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	t.Run("NoCredentials", func(t *testing.T) {
+		setCredentials(accessCredentials{})
+		if status := serve(httptest.NewRequest("GET", "/", nil)).Code; status != http.StatusOK {
+			t.Errorf("Expected requests to pass without credentials, got %d", status)
+		}
+	})
+
+	t.Run("Token", func(t *testing.T) {
+		setCredentials(accessCredentials{token: "s3cret"})
+
+		resp := serve(httptest.NewRequest("GET", "/", nil))
+		if resp.Code != http.StatusUnauthorized || resp.Header().Get("WWW-Authenticate") != "" {
+			t.Errorf("Expected 401 without a basic-auth challenge, got %d %v", resp.Code, resp.Header())
+		}
+
+		resp = serve(httptest.NewRequest("GET", "/?file=/tmp/a.md&token=s3cret", nil))
+		if resp.Code != http.StatusSeeOther {
+			t.Fatalf("Expected a redirect for a valid token, got %d", resp.Code)
+		}
+		if location := resp.Header().Get("Location"); location != "/?file=%2Ftmp%2Fa.md" {
+			t.Errorf("Expected the token to be dropped from the URL, got %s", location)
+		}
+		cookies := resp.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != tokenCookie || !cookies[0].HttpOnly {
+			t.Fatalf("Expected an HttpOnly token cookie, got %v", cookies)
+		}
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(cookies[0])
+		if status := serve(req).Code; status != http.StatusOK {
+			t.Errorf("Expected the cookie to authorize the request, got %d", status)
+		}
+
+		req = httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		if status := serve(req).Code; status != http.StatusOK {
+			t.Errorf("Expected the bearer token to authorize the request, got %d", status)
+		}
+
+		for _, target := range []string{"/?token=wrong", "/?token="} {
+			if status := serve(httptest.NewRequest("GET", target, nil)).Code; status != http.StatusUnauthorized {
+				t.Errorf("Expected 401 for %s, got %d", target, status)
+			}
+		}
+	})
+
+	t.Run("BasicAuth", func(t *testing.T) {
+		setCredentials(accessCredentials{user: "alice", password: "secret"})
+
+		resp := serve(httptest.NewRequest("GET", "/", nil))
+		if resp.Code != http.StatusUnauthorized || resp.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Expected 401 with a basic-auth challenge, got %d %v", resp.Code, resp.Header())
+		}
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.SetBasicAuth("alice", "secret")
+		if status := serve(req).Code; status != http.StatusOK {
+			t.Errorf("Expected valid credentials to authorize the request, got %d", status)
+		}
+
+		req = httptest.NewRequest("GET", "/", nil)
+		req.SetBasicAuth("alice", "wrong")
+		if status := serve(req).Code; status != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for a wrong password, got %d", status)
+		}
+
+		// Without a token, ?token= must not be accepted
+		if status := serve(httptest.NewRequest("GET", "/?token=", nil)).Code; status != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for an empty token, got %d", status)
+		}
+	})
+
+	t.Run("APIToken", func(t *testing.T) {
+		setCredentials(accessCredentials{token: "s3cret"})
+		apiTokenLock.Lock()
+		apiToken = "api-token"
+		apiTokenLock.Unlock()
+		t.Cleanup(func() {
+			apiTokenLock.Lock()
+			apiToken = ""
+			apiTokenLock.Unlock()
+		})
+
+		req := httptest.NewRequest("GET", "/api/files", nil)
+		req.Header.Set("Authorization", "Bearer api-token")
+		if status := serve(req).Code; status != http.StatusOK {
+			t.Errorf("Expected the API token to pass, got %d", status)
+		}
+	})
+
+	t.Run("APIPreflight", func(t *testing.T) {
+		setCredentials(accessCredentials{token: "s3cret"})
+
+		req := httptest.NewRequest("OPTIONS", "/api/files", nil)
+		req.Header.Set("Access-Control-Request-Method", "GET")
+		if status := serve(req).Code; status != http.StatusOK {
+			t.Errorf("Expected API preflight requests to pass, got %d", status)
+		}
+
+		req = httptest.NewRequest("OPTIONS", "/", nil)
+		req.Header.Set("Access-Control-Request-Method", "GET")
+		if status := serve(req).Code; status != http.StatusUnauthorized {
+			t.Errorf("Expected preflight requests outside the API to be refused, got %d", status)
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// maxPushSize limits the size of an editor buffer pushed over the control socket
+const maxPushSize = 16 << 20
+
+// pushParams are the parameters of the "push" method
+type pushParams struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+// pushBuffer renders the content of an unsaved editor buffer for a tracked file or stdin document and tells its
+// viewers to reload. The file's page shows the buffer until the watcher sees the file being saved. Returns the
+// URL the file is served at.
+func pushBuffer(filePath string, content []byte) (string, *controlError) {
+	filesLock.RLock()
+	fileState, exists := files[filePath]
+	filesLock.RUnlock()
+
+	if !exists {
+		return "", &controlError{Code: errCodeNotTracked, Message: fmt.Sprintf("file not tracked: %s", filePath)}
+	}
+
+	// Documents read from stdin have no saved file, the pushed content replaces them
+	unsaved := false
+	if fileState.stdin {
+		fileState.contentLock.Lock()
+		fileState.source = content
+		fileState.contentLock.Unlock()
+	} else {
+		// A buffer pushed right after saving matches the file and is not shown as unsaved
+		saved, err := os.ReadFile(filePath)
+		unsaved = err != nil || !bytes.Equal(saved, content)
+	}
+
+	if err := fileState.render(content, unsaved); err != nil {
+		return "", &controlError{Code: errCodeInternal, Message: err.Error()}
+	}
+	if fileState.stdin {
+		saveState()
+	}
+
+	slog.Debug("Rendered pushed buffer", "path", filePath, "bytes", len(content), "unsaved", unsaved)
+	notifyClients(filePath, "reload")
+
+	return fileURL(serverPort(), filePath), nil
+}
+
+// readPushCommand reads the buffer of a legacy "PUSH <path> <length>" command, whose arguments are given in args.
+// The path may contain spaces, the length is the number of bytes following the command line.
+func readPushCommand(reader *bufio.Reader, args string) (string, []byte, error) {
+	separator := strings.LastIndex(args, " ")
+	if separator <= 0 {
+		return "", nil, fmt.Errorf("invalid command: expected 'PUSH <path> <length>'")
+	}
+
+	filePath := args[:separator]
+	length, err := strconv.Atoi(args[separator+1:])
+	if err != nil || length < 0 {
+		return "", nil, fmt.Errorf("invalid length: %s", args[separator+1:])
+	}
+	if length > maxPushSize {
+		return "", nil, fmt.Errorf("buffer too large: %d bytes (at most %d)", length, maxPushSize)
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(reader, content); err != nil {
+		return "", nil, fmt.Errorf("failed to read buffer: %w", err)
+	}
+
+	return filePath, content, nil
+}
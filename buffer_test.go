@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// documentContent returns the rendered content of a tracked file and whether it shows an unsaved buffer
+func documentContent(filePath string) (string, bool) {
+	filesLock.RLock()
+	fileState := files[filePath]
+	filesLock.RUnlock()
+
+	fileState.contentLock.RLock()
+	defer fileState.contentLock.RUnlock()
+	return string(fileState.htmlContent), fileState.unsaved
+}
+
+func TestPushBuffer(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.md")
+	if err := os.WriteFile(testFile, []byte("# Saved"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := addFile(testFile); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = removeFile(testFile)
+	})
+
+	t.Run("Unsaved", func(t *testing.T) {
+		if _, cerr := pushBuffer(testFile, []byte("# Typing")); cerr != nil {
+			t.Fatalf("Failed to push buffer: %v", cerr)
+		}
+
+		content, unsaved := documentContent(testFile)
+		if !strings.Contains(content, "Typing") || !unsaved {
+			t.Errorf("Expected the buffer to be shown as unsaved, got %q (unsaved=%v)", content, unsaved)
+		}
+
+		w := httptest.NewRecorder()
+		handleIndex(w, httptest.NewRequest("GET", "/?file="+testFile, nil))
+		if !strings.Contains(w.Body.String(), "Unsaved buffer") {
+			t.Error("Expected the page to show that the buffer is unsaved")
+		}
+	})
+
+	t.Run("SavedFileTakesOver", func(t *testing.T) {
+		time.Sleep(200 * time.Millisecond)
+		if err := os.WriteFile(testFile, []byte("# Typing and saved"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		deadline := time.Now().Add(3 * time.Second)
+		for time.Now().Before(deadline) {
+			if content, unsaved := documentContent(testFile); strings.Contains(content, "saved") && !unsaved {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		content, unsaved := documentContent(testFile)
+		if !strings.Contains(content, "Typing and saved") || unsaved {
+			t.Errorf("Expected the saved file to be shown, got %q (unsaved=%v)", content, unsaved)
+		}
+
+		w := httptest.NewRecorder()
+		handleIndex(w, httptest.NewRequest("GET", "/?file="+testFile, nil))
+		if !strings.Contains(w.Body.String(), "Saved file") {
+			t.Error("Expected the page to show that the file is saved")
+		}
+	})
+
+	t.Run("BufferMatchingSavedFile", func(t *testing.T) {
+		if _, cerr := pushBuffer(testFile, []byte("# Typing and saved")); cerr != nil {
+			t.Fatalf("Failed to push buffer: %v", cerr)
+		}
+		if _, unsaved := documentContent(testFile); unsaved {
+			t.Error("Expected a buffer matching the saved file not to be shown as unsaved")
+		}
+	})
+
+	t.Run("StdinDocument", func(t *testing.T) {
+		id, err := addStdinDocument([]byte("# Piped"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = removeFile(id)
+		})
+
+		if _, cerr := pushBuffer(id, []byte("# Replaced")); cerr != nil {
+			t.Fatalf("Failed to push buffer: %v", cerr)
+		}
+		if err := renderMarkdown(id); err != nil {
+			t.Fatal(err)
+		}
+
+		content, unsaved := documentContent(id)
+		if !strings.Contains(content, "Replaced") || unsaved {
+			t.Errorf("Expected the pushed content to replace the document, got %q (unsaved=%v)", content, unsaved)
+		}
+	})
+
+	t.Run("NotTracked", func(t *testing.T) {
+		_, cerr := pushBuffer(filepath.Join(tmpDir, "other.md"), []byte("# Other"))
+		if cerr == nil || cerr.Code != errCodeNotTracked {
+			t.Errorf("Expected %s error, got %v", errCodeNotTracked, cerr)
+		}
+	})
+}
+
+func TestReadPushCommand(t *testing.T) {
+	t.Run("PathWithSpaces", func(t *testing.T) {
+		reader := bufio.NewReader(strings.NewReader("# Buffer\nnext"))
+		filePath, content, err := readPushCommand(reader, "/tmp/my notes.md 9")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filePath != "/tmp/my notes.md" || string(content) != "# Buffer\n" {
+			t.Errorf("Unexpected push of %q: %q", filePath, content)
+		}
+	})
+
+	invalid := []string{"/tmp/a.md", "/tmp/a.md many", "/tmp/a.md -1", "/tmp/a.md 100", "/tmp/a.md 99999999999"}
+	for _, args := range invalid {
+		reader := bufio.NewReader(strings.NewReader("# Short"))
+		if _, _, err := readPushCommand(reader, args); err == nil {
+			t.Errorf("Expected error for %q", args)
+		}
+	}
+}
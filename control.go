@@ -2,19 +2,22 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
-// getSocketPath returns the platform-specific Unix domain socket path for the control socket.
+// getRuntimeDir returns the platform-specific directory holding the control socket and log file.
 // Uses XDG_RUNTIME_DIR on Linux, falls back to /tmp/lum-$UID/ if not available.
 // On macOS, uses os.TempDir() to avoid CGo dependency
 // (ideally would use confstr(_CS_DARWIN_USER_TEMP_DIR) but avoiding CGo).
-func getSocketPath() (string, error) {
+func getRuntimeDir() (string, error) {
 	var baseDir string
 
 	// Try XDG_RUNTIME_DIR first (Linux standard)
@@ -28,148 +31,482 @@ func getSocketPath() (string, error) {
 
 	// Ensure directory exists
 	if err := os.MkdirAll(baseDir, 0o700); err != nil {
-		return "", fmt.Errorf("failed to create socket directory: %w", err)
+		return "", err
+	}
+
+	// Refuse to use a directory other users could plant a socket or read the log in
+	if err := checkPrivateDir(baseDir); err != nil {
+		return "", fmt.Errorf("insecure runtime directory (fix or remove it): %w", err)
+	}
+
+	return baseDir, nil
+}
+
+// getSocketPath returns the Unix domain socket path for the control socket of the selected instance
+func getSocketPath() (string, error) {
+	baseDir, err := getInstanceDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get socket directory: %w", err)
 	}
 
 	return filepath.Join(baseDir, "control.sock"), nil
 }
 
+// getLogPath returns the path of the log file of the selected instance
+func getLogPath() (string, error) {
+	baseDir, err := getInstanceDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get log directory: %w", err)
+	}
+
+	return filepath.Join(baseDir, "lum.log"), nil
+}
+
 // startControlSocket starts a Unix domain socket listener and handles incoming control commands.
 // This allows new lum invocations to communicate with an existing server instance.
-func startControlSocket(port int) error {
+func startControlSocket() error {
+	if err := createInstanceDir(); err != nil {
+		return err
+	}
 	socketPath, err := getSocketPath()
 	if err != nil {
 		return fmt.Errorf("failed to get socket path: %w", err)
 	}
 
-	// Remove existing socket if it exists (in case of unclean shutdown)
-	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to remove existing socket: %w", err)
+	// systemd owns a socket it passed to us, it must be used as it is
+	listener := activatedControl
+	if listener == nil {
+		// Never take the socket away from a server that still answers on it, unless it hands over to this one
+		if !takingOver {
+			if conn, err := net.Dial("unix", socketPath); err == nil {
+				_ = conn.Close()
+				return fmt.Errorf("another server is already listening on %s", socketPath)
+			}
+		}
+
+		// Remove existing socket if it exists (in case of unclean shutdown)
+		if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove existing socket: %w", err)
+		}
+
+		listener, err = net.Listen("unix", socketPath)
+		if err != nil {
+			return fmt.Errorf("failed to create socket listener: %w", err)
+		}
 	}
 
-	listener, err := net.Listen("unix", socketPath)
-	if err != nil {
-		return fmt.Errorf("failed to create socket listener: %w", err)
+	if err := writePIDFile(socketPath); err != nil {
+		_ = listener.Close()
+		return fmt.Errorf("failed to write PID file: %w", err)
+	}
+	if err := writeAPIToken(socketPath); err != nil {
+		_ = listener.Close()
+		return err
 	}
 
-	log.Printf("Control socket listening at %s", socketPath)
+	slog.Info("Control socket listening", "path", socketPath)
 
 	go func() {
 		defer func() {
 			if err := listener.Close(); err != nil {
-				log.Printf("Failed to close listener: %v", err)
+				slog.Error("Failed to close listener", "error", err)
 			}
 		}()
 		for {
 			conn, err := listener.Accept()
 			if err != nil {
-				log.Printf("Failed to accept connection: %v", err)
+				slog.Error("Failed to accept connection", "error", err)
+				continue
+			}
+			if err := checkPeer(conn); err != nil {
+				slog.Warn("Rejected control connection", "error", err)
+				if err := conn.Close(); err != nil {
+					slog.Error("Failed to close connection", "error", err)
+				}
 				continue
 			}
-			go handleControlCommand(conn, port)
+			go handleControlCommand(conn)
 		}
 	}()
 
 	return nil
 }
 
-// handleControlCommand processes a single control command from a client connection.
-// Protocol: "ADD /absolute/path/to/file.md\n" or "STOP\n"
-// Response: "OK <url>\n" or "ERROR <message>\n"
-func handleControlCommand(conn net.Conn, port int) {
+// daemonStatus describes the running daemon as reported by the STATUS control command
+type daemonStatus struct {
+	Instance   string    `json:"instance"`
+	Mode       string    `json:"mode"`
+	PID        int       `json:"pid"`
+	Address    string    `json:"address"`
+	Version    string    `json:"version"`
+	StartedAt  time.Time `json:"started_at"`
+	LogPath    string    `json:"log_path"`
+	SocketPath string    `json:"socket_path"`
+	TokenPath  string    `json:"token_path,omitempty"`
+}
+
+// currentStatus collects the status of this daemon process
+func currentStatus() daemonStatus {
+	status := daemonStatus{
+		Instance:  instanceName,
+		Mode:      serverMode,
+		PID:       os.Getpid(),
+		Address:   serverAddr,
+		Version:   Version,
+		StartedAt: startedAt,
+	}
+
+	// A daemon in the foreground logs to stderr
+	if logPath, err := getLogPath(); err == nil && (daemonOptions == nil || !daemonOptions.foreground) {
+		status.LogPath = logPath
+	}
+	if socketPath, err := getSocketPath(); err == nil {
+		status.SocketPath = socketPath
+		status.TokenPath = apiTokenPathFor(socketPath)
+	}
+
+	return status
+}
+
+// handleControlCommand serves a single client connection on the control socket.
+// Clients speaking the JSON protocol (see protocol.go) start with a JSON object, anything else is
+// treated as a single command of the legacy text protocol (see handleLegacyCommand).
+func handleControlCommand(conn net.Conn) {
 	defer func() {
 		if err := conn.Close(); err != nil {
-			log.Printf("Failed to close connection: %v", err)
+			slog.Error("Failed to close connection", "error", err)
 		}
 	}()
 
 	reader := bufio.NewReader(conn)
 	line, err := reader.ReadString('\n')
 	if err != nil {
-		log.Printf("Failed to read from control socket: %v", err)
+		slog.Error("Failed to read from control socket", "error", err)
+		return
+	}
+	recordActivity()
+
+	if strings.HasPrefix(strings.TrimSpace(line), "{") {
+		serveProtocolSession(conn, reader, line)
 		return
 	}
 
-	line = strings.TrimSpace(line)
+	handleLegacyCommand(conn, reader, strings.TrimSpace(line))
+}
+
+// handleLegacyCommand processes a single command of the legacy text protocol.
+// Protocol: "ADD /absolute/path/to/file.md\n", "REMOVE /absolute/path/to/file.md\n", "LIST\n",
+// "STATUS\n", "STOP\n" or "PUSH /absolute/path/to/file.md <length>\n<bytes>"
+// Response: "OK <url>\n" for ADD and PUSH, "OK\n" for REMOVE, "OK <json>\n" for LIST and STATUS,
+// "OK <pid>\n" for STOP, or "ERROR <message>\n"
+func handleLegacyCommand(conn net.Conn, reader *bufio.Reader, line string) {
 	parts := strings.SplitN(line, " ", 2)
 	command := parts[0]
 
 	switch command {
 	case "STOP":
-		log.Println("Received STOP command, shutting down...")
-		if err := conn.Close(); err != nil {
-			log.Printf("Failed to close connection: %v", err)
-		}
-		cleanupSocket()
-		os.Exit(0)
+		writeLegacyResponse(conn, "OK %d", os.Getpid())
+		stopServer()
 
 	case "ADD":
 		if len(parts) != 2 {
-			if _, err := fmt.Fprintf(conn, "ERROR invalid command: expected 'ADD <path>'\n"); err != nil {
-				log.Printf("Failed to write error response: %v", err)
-			}
+			writeLegacyResponse(conn, "ERROR invalid command: expected 'ADD <path>'")
 			return
 		}
 
-		filePath := parts[1]
+		url, cerr := addTrackedFile(parts[1], directoryFilter{})
+		if cerr != nil {
+			writeLegacyResponse(conn, "ERROR %s", cerr.Message)
+			return
+		}
+		writeLegacyResponse(conn, "OK %s", url)
 
-		// Validate file exists
-		if _, err := os.Stat(filePath); os.IsNotExist(err) {
-			if _, err := fmt.Fprintf(conn, "ERROR file does not exist: %s\n", filePath); err != nil {
-				log.Printf("Failed to write error response: %v", err)
-			}
+	case "REMOVE":
+		if len(parts) != 2 {
+			writeLegacyResponse(conn, "ERROR invalid command: expected 'REMOVE <path>'")
 			return
 		}
 
-		// Add file to tracked files
-		if err := addFile(filePath); err != nil {
-			if _, err := fmt.Fprintf(conn, "ERROR failed to add file: %v\n", err); err != nil {
-				log.Printf("Failed to write error response: %v", err)
-			}
+		if cerr := removeTrackedFile(parts[1]); cerr != nil {
+			writeLegacyResponse(conn, "ERROR %s", cerr.Message)
+			return
+		}
+		writeLegacyResponse(conn, "OK")
+
+	case "PUSH":
+		if len(parts) != 2 {
+			writeLegacyResponse(conn, "ERROR invalid command: expected 'PUSH <path> <length>'")
+			return
+		}
+
+		filePath, content, err := readPushCommand(reader, parts[1])
+		if err != nil {
+			writeLegacyResponse(conn, "ERROR %v", err)
+			return
+		}
+		url, cerr := pushBuffer(filePath, content)
+		if cerr != nil {
+			writeLegacyResponse(conn, "ERROR %s", cerr.Message)
 			return
 		}
+		writeLegacyResponse(conn, "OK %s", url)
 
-		url := fmt.Sprintf("http://localhost:%d/?file=%s", port, filePath)
-		if _, err := fmt.Fprintf(conn, "OK %s\n", url); err != nil {
-			log.Printf("Failed to write success response: %v", err)
+	case "LIST":
+		data, err := json.Marshal(listFiles(serverPort()))
+		if err != nil {
+			writeLegacyResponse(conn, "ERROR failed to encode file list: %v", err)
 			return
 		}
-		log.Printf("Added file via control socket: %s", filePath)
+		writeLegacyResponse(conn, "OK %s", data)
+
+	case "STATUS":
+		data, err := json.Marshal(currentStatus())
+		if err != nil {
+			writeLegacyResponse(conn, "ERROR failed to encode status: %v", err)
+			return
+		}
+		writeLegacyResponse(conn, "OK %s", data)
 
 	default:
-		if _, err := fmt.Fprintf(conn, "ERROR invalid command: expected 'ADD <path>' or 'STOP'\n"); err != nil {
-			log.Printf("Failed to write error response: %v", err)
+		writeLegacyResponse(
+			conn, "ERROR invalid command: expected 'ADD <path>', 'REMOVE <path>', 'PUSH <path> <length>', 'LIST', "+
+				"'STATUS' or 'STOP'",
+		)
+	}
+}
+
+// writeLegacyResponse writes a single response line of the legacy text protocol
+func writeLegacyResponse(conn net.Conn, format string, args ...any) {
+	if _, err := fmt.Fprintf(conn, format+"\n", args...); err != nil {
+		slog.Error("Failed to write response", "error", err)
+	}
+}
+
+// addTrackedFile validates and adds a file on behalf of a control client, returning its URL on the port
+// the server is bound to. A directory is tracked with the files below it selected by filter, and its URL is
+// that of the index page.
+func addTrackedFile(filePath string, filter directoryFilter) (string, *controlError) {
+	// Validate file exists
+	info, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return "", &controlError{Code: errCodeFileNotFound, Message: fmt.Sprintf("file does not exist: %s", filePath)}
+	}
+
+	if err == nil && info.IsDir() {
+		if err := filter.validate(); err != nil {
+			return "", &controlError{Code: errCodeInvalidRequest, Message: err.Error()}
+		}
+		if err := addDirectory(filePath, filter); err != nil {
+			return "", &controlError{Code: errCodeInternal, Message: fmt.Sprintf("failed to add directory: %v", err)}
 		}
+
+		slog.Info("Added directory via control socket", "path", filePath)
+		return indexURL(serverPort()), nil
 	}
+
+	// Add file to tracked files
+	if err := addFile(filePath); err != nil {
+		return "", &controlError{Code: errCodeInternal, Message: fmt.Sprintf("failed to add file: %v", err)}
+	}
+
+	slog.Info("Added file via control socket", "path", filePath)
+	return fileURL(serverPort(), filePath), nil
+}
+
+// addTrackedFiles adds several files on behalf of a control client. A file that cannot be added does not keep
+// the other files from being added.
+func addTrackedFiles(paths []string, filter directoryFilter) []addFileResult {
+	results := make([]addFileResult, 0, len(paths))
+	for _, path := range paths {
+		url, cerr := addTrackedFile(path, filter)
+		results = append(results, addFileResult{Path: path, URL: url, Error: cerr})
+	}
+
+	return results
+}
+
+// removeTrackedFile removes a file or a directory on behalf of a control client
+func removeTrackedFile(filePath string) *controlError {
+	if isTrackedDirectory(filePath) {
+		if err := removeDirectory(filePath); err != nil {
+			return &controlError{Code: errCodeNotTracked, Message: err.Error()}
+		}
+
+		slog.Info("Removed directory via control socket", "path", filePath)
+		return nil
+	}
+
+	if err := removeFile(filePath); err != nil {
+		return &controlError{Code: errCodeNotTracked, Message: err.Error()}
+	}
+
+	slog.Info("Removed file via control socket", "path", filePath)
+	return nil
+}
+
+// stopServer starts a graceful shutdown on behalf of a control client
+func stopServer() {
+	slog.Info("Received STOP command, shutting down")
+	requestShutdown()
 }
 
 // tryAddToExistingServer attempts to add a file to an existing server instance via the control socket.
 // Returns the URL where the file can be accessed if successful, or an error if no server is running
 // or the request fails.
 func tryAddToExistingServer(filePath string) (string, error) {
-	socketPath, err := getSocketPath()
+	client, err := dialControl()
+	if errors.Is(err, errLegacyServer) {
+		return sendLegacyCommand(fmt.Sprintf("ADD %s", filePath))
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to get socket path: %w", err)
+		return "", err
+	}
+	defer closeControlClient(client)
+
+	var result addResult
+	if err := client.call("add", pathParams{Path: filePath}, &result); err != nil {
+		return "", fmt.Errorf("server error: %w", err)
+	}
+
+	return result.URL, nil
+}
+
+// addFilesToExistingServer adds files to the running server in a single request and returns the outcome for
+// every file. Directories are tracked with the files below them selected by filter. Servers that predate the
+// "add_files" method are sent one request per file instead.
+func addFilesToExistingServer(paths []string, filter directoryFilter) ([]addFileResult, error) {
+	client, err := dialControl()
+	if errors.Is(err, errLegacyServer) {
+		results := make([]addFileResult, 0, len(paths))
+		for _, path := range paths {
+			url, err := sendLegacyCommand(fmt.Sprintf("ADD %s", path))
+			results = append(results, newAddFileResult(path, url, err))
+		}
+		return results, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer closeControlClient(client)
+
+	var result addFilesResult
+	err = client.call("add_files", pathsParams{Paths: paths, directoryFilter: filter}, &result)
+	var cerr *controlError
+	if errors.As(err, &cerr) && cerr.Code == errCodeUnknownMethod {
+		results := make([]addFileResult, 0, len(paths))
+		for _, path := range paths {
+			var added addResult
+			err := client.call("add", pathParams{Path: path}, &added)
+			results = append(results, newAddFileResult(path, added.URL, err))
+		}
+		return results, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("server error: %w", err)
+	}
+
+	return result.Files, nil
+}
+
+// newAddFileResult describes the outcome of adding a single file
+func newAddFileResult(path, url string, err error) addFileResult {
+	if err == nil {
+		return addFileResult{Path: path, URL: url}
+	}
+
+	var cerr *controlError
+	if !errors.As(err, &cerr) {
+		cerr = &controlError{Code: errCodeInternal, Message: err.Error()}
+	}
+	return addFileResult{Path: path, Error: cerr}
+}
+
+// removeFromExistingServer asks the running server to stop tracking a file via the control socket.
+func removeFromExistingServer(filePath string) error {
+	return callExistingServer("remove", pathParams{Path: filePath}, nil)
+}
+
+// listExistingServerFiles asks the running server for the files it is currently tracking
+func listExistingServerFiles() ([]trackedFileInfo, error) {
+	var result listResult
+	if err := callExistingServer("list", nil, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Files, nil
+}
+
+// getExistingServerStatus asks the running server to describe itself
+func getExistingServerStatus() (*daemonStatus, error) {
+	var status daemonStatus
+	if err := callExistingServer("status", nil, &status); err != nil {
+		return nil, err
 	}
 
-	// Check if socket exists
-	if _, err := os.Stat(socketPath); os.IsNotExist(err) {
-		return "", fmt.Errorf("no existing server (socket does not exist)")
+	return &status, nil
+}
+
+// callExistingServer performs a single request on a fresh connection to the running server
+func callExistingServer(method string, params, result any) error {
+	client, err := dialControl()
+	if err != nil {
+		return err
+	}
+	defer closeControlClient(client)
+
+	if err := client.call(method, params, result); err != nil {
+		var cerr *controlError
+		if errors.As(err, &cerr) && cerr.Code == errCodeUnknownMethod {
+			return fmt.Errorf(
+				"the running daemon (lum %s) does not support '%s', "+
+					"run 'lum --restart' to switch it to this version: %w",
+				client.version, method, err,
+			)
+		}
+		return fmt.Errorf("server error: %w", err)
+	}
+
+	return nil
+}
+
+// promoteExistingServer asks the running one-off server to hand over to a new daemon and returns the daemon's URL
+func promoteExistingServer() (string, error) {
+	var result handOverResult
+	if err := callExistingServer("promote", nil, &result); err != nil {
+		return "", err
+	}
+
+	return result.URL, nil
+}
+
+// closeControlClient closes a control client, logging any failure
+func closeControlClient(client *controlClient) {
+	if err := client.Close(); err != nil {
+		slog.Error("Failed to close connection", "error", err)
+	}
+}
+
+// sendLegacyCommand sends a single command line of the legacy text protocol to the running server
+// and returns the payload of its "OK" response. It is used to talk to servers that predate the
+// JSON protocol. An "ERROR" response, or a failure to reach the server, is returned as an error.
+func sendLegacyCommand(command string) (string, error) {
+	socketPath, err := getSocketPath()
+	if err != nil {
+		return "", fmt.Errorf("failed to get socket path: %w", err)
 	}
 
-	// Try to connect to the socket
-	conn, err := net.Dial("unix", socketPath)
+	conn, err := dialSocket(socketPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to connect to existing server: %w", err)
+		return "", err
 	}
 	defer func() {
 		if err := conn.Close(); err != nil {
-			log.Printf("Failed to close connection: %v", err)
+			slog.Error("Failed to close connection", "error", err)
 		}
 	}()
 
-	// Send ADD command
-	if _, err := fmt.Fprintf(conn, "ADD %s\n", filePath); err != nil {
+	// Send command
+	if _, err := fmt.Fprintf(conn, "%s\n", command); err != nil {
 		return "", fmt.Errorf("failed to send command: %w", err)
 	}
 
@@ -182,60 +519,61 @@ func tryAddToExistingServer(filePath string) (string, error) {
 
 	response = strings.TrimSpace(response)
 
-	if url, found := strings.CutPrefix(response, "OK "); found {
-		return url, nil
+	if response == "OK" {
+		return "", nil
 	}
 
-	if url, found := strings.CutPrefix(response, "ERROR "); found {
-		return "", fmt.Errorf("server error: %s", url)
+	if payload, found := strings.CutPrefix(response, "OK "); found {
+		return payload, nil
+	}
+
+	if message, found := strings.CutPrefix(response, "ERROR "); found {
+		return "", fmt.Errorf("server error: %s", message)
 	}
 
 	return "", fmt.Errorf("unexpected response: %s", response)
 }
 
-// setupLogFile creates and configures logging to a file in the runtime directory
+// setupLogFile creates and configures logging to a file in the runtime directory, rotated by size
 func setupLogFile() error {
-	var baseDir string
-
-	// Use same directory as socket
-	if xdgDir := os.Getenv("XDG_RUNTIME_DIR"); xdgDir != "" {
-		baseDir = filepath.Join(xdgDir, "lum")
-	} else {
-		uid := os.Getuid()
-		baseDir = filepath.Join(os.TempDir(), fmt.Sprintf("lum-%d", uid))
+	if err := createInstanceDir(); err != nil {
+		return err
 	}
-
-	// Ensure directory exists
-	if err := os.MkdirAll(baseDir, 0o700); err != nil {
-		return fmt.Errorf("failed to create log directory: %w", err)
+	logPath, err := getLogPath()
+	if err != nil {
+		return err
 	}
 
-	logPath := filepath.Join(baseDir, "lum.log")
-	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	logFile, err := openRotatingLogFile(logPath, logMaxSize, logKeep)
 	if err != nil {
-		return fmt.Errorf("failed to open log file: %w", err)
+		return err
 	}
 
-	log.SetOutput(logFile)
-	log.Printf("Daemon starting, logging to %s", logPath)
+	setupLogger(logFile)
+	slog.Info("Daemon starting", "log", logPath)
 
 	return nil
 }
 
-// dialSocket connects to the control socket
-func dialSocket(socketPath string) (net.Conn, error) {
-	return net.Dial("unix", socketPath)
-}
-
-// cleanupSocket removes the control socket on shutdown
+// cleanupSocket removes the control socket, PID file and API token on shutdown
 func cleanupSocket() {
+	// After a handover the socket and PID file belong to the new daemon
+	if handedOff {
+		return
+	}
+
 	socketPath, err := getSocketPath()
 	if err != nil {
-		log.Printf("Failed to get socket path for cleanup: %v", err)
+		slog.Error("Failed to get socket path for cleanup", "error", err)
 		return
 	}
 
-	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
-		log.Printf("Failed to remove socket: %v", err)
+	// systemd keeps listening on a socket it passed to us, to start the daemon again on the next connection
+	if activatedControl == nil {
+		if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+			slog.Error("Failed to remove socket", "error", err)
+		}
 	}
+	removePIDFile(socketPath)
+	removeAPIToken(socketPath)
 }
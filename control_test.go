@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
 	"net"
 	"os"
@@ -81,10 +83,27 @@ func TestGetSocketPath(t *testing.T) {
 	})
 }
 
+// startTestDaemon starts a daemon in the background and shuts it down when the test ends. Daemons share the
+// shutdown channel and global settings, so none may be left running for later tests.
+func startTestDaemon(t *testing.T, opts *options, docs documents) {
+	t.Helper()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- startDaemon(opts, docs)
+	}()
+	t.Cleanup(func() {
+		requestShutdown()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Error("Daemon did not shut down")
+		}
+	})
+}
+
 func TestStartControlSocket(t *testing.T) {
 	t.Run("SuccessfulStart", func(t *testing.T) {
-		port := 16400
-
 		// Use a unique socket path for this test
 		tmpDir := t.TempDir()
 		oldXDG := os.Getenv("XDG_RUNTIME_DIR")
@@ -104,7 +123,7 @@ func TestStartControlSocket(t *testing.T) {
 			}
 		})
 
-		err := startControlSocket(port)
+		err := startControlSocket()
 		if err != nil {
 			t.Fatalf("Failed to start control socket: %v", err)
 		}
@@ -124,8 +143,6 @@ func TestStartControlSocket(t *testing.T) {
 	})
 
 	t.Run("RestartsWithExistingSocket", func(t *testing.T) {
-		port := 16401
-
 		tmpDir := t.TempDir()
 		oldXDG := os.Getenv("XDG_RUNTIME_DIR")
 		if err := os.Setenv("XDG_RUNTIME_DIR", tmpDir); err != nil {
@@ -145,7 +162,7 @@ func TestStartControlSocket(t *testing.T) {
 		})
 
 		// Start first socket
-		err := startControlSocket(port)
+		err := startControlSocket()
 		if err != nil {
 			t.Fatalf("Failed to start first control socket: %v", err)
 		}
@@ -156,11 +173,42 @@ func TestStartControlSocket(t *testing.T) {
 		cleanupSocket()
 		time.Sleep(100 * time.Millisecond)
 
-		err = startControlSocket(port)
+		err = startControlSocket()
 		if err != nil {
 			t.Fatalf("Failed to restart control socket: %v", err)
 		}
 	})
+
+	t.Run("RefusesLiveServer", func(t *testing.T) {
+		t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+
+		if err := createInstanceDir(); err != nil {
+			t.Fatal(err)
+		}
+		socketPath, err := getSocketPath()
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener, err := net.Listen("unix", socketPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = listener.Close() }()
+
+		if err := startControlSocket(); err == nil || !strings.Contains(err.Error(), "already listening") {
+			t.Fatalf("Expected the socket of a live server to be refused, got %v", err)
+		}
+		if _, err := readPIDFile(socketPath); err == nil {
+			t.Error("Expected no PID file to be written")
+		}
+
+		// The server keeps its socket
+		conn, err := net.Dial("unix", socketPath)
+		if err != nil {
+			t.Fatalf("Expected the live server to keep its socket: %v", err)
+		}
+		_ = conn.Close()
+	})
 }
 
 func TestHandleControlCommand(t *testing.T) {
@@ -192,8 +240,11 @@ func TestHandleControlCommand(t *testing.T) {
 		}
 	})
 
+	// Control clients are given URLs on the port the server is bound to
+	serverAddr = fmt.Sprintf("127.0.0.1:%d", port)
+
 	// Start control socket
-	if err := startControlSocket(port); err != nil {
+	if err := startControlSocket(); err != nil {
 		t.Fatal(err)
 	}
 	time.Sleep(100 * time.Millisecond)
@@ -250,7 +301,8 @@ func TestHandleControlCommand(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		expectedResponse := "ERROR invalid command: expected 'ADD <path>' or 'STOP'\n"
+		expectedResponse := "ERROR invalid command: " +
+			"expected 'ADD <path>', 'REMOVE <path>', 'PUSH <path> <length>', 'LIST', 'STATUS' or 'STOP'\n"
 		actualResponse := string(buf[:n])
 		if actualResponse != expectedResponse {
 			t.Errorf("Expected response:\n%q\nGot:\n%q", expectedResponse, actualResponse)
@@ -314,6 +366,238 @@ func TestHandleControlCommand(t *testing.T) {
 			t.Errorf("Expected response:\n%q\nGot:\n%q", expectedResponse, actualResponse)
 		}
 	})
+
+	t.Run("PushBuffer", func(t *testing.T) {
+		socketPath, err := getSocketPath()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn, err := net.Dial("unix", socketPath)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer func() { _ = conn.Close() }()
+
+		buffer := "# Unsaved Heading\n"
+		if _, err := fmt.Fprintf(conn, "PUSH %s %d\n%s", testFile, len(buffer), buffer); err != nil {
+			t.Fatal(err)
+		}
+
+		response, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectedResponse := fmt.Sprintf("OK http://localhost:%d/?file=%s\n", port, testFile)
+		if response != expectedResponse {
+			t.Errorf("Expected response:\n%q\nGot:\n%q", expectedResponse, response)
+		}
+
+		filesLock.RLock()
+		fileState := files[testFile]
+		filesLock.RUnlock()
+		fileState.contentLock.RLock()
+		content, unsaved := string(fileState.htmlContent), fileState.unsaved
+		fileState.contentLock.RUnlock()
+		if !strings.Contains(content, "Unsaved Heading") || !unsaved {
+			t.Errorf("Expected the pushed buffer to be shown as unsaved, got %q (unsaved=%v)", content, unsaved)
+		}
+	})
+
+	t.Run("ListFiles", func(t *testing.T) {
+		socketPath, err := getSocketPath()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn, err := net.Dial("unix", socketPath)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer func() { _ = conn.Close() }()
+
+		if _, err := fmt.Fprintf(conn, "LIST\n"); err != nil {
+			t.Fatal(err)
+		}
+
+		response, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		payload, found := strings.CutPrefix(strings.TrimSpace(response), "OK ")
+		if !found {
+			t.Fatalf("Expected OK response, got: %q", response)
+		}
+
+		var list []trackedFileInfo
+		if err := json.Unmarshal([]byte(payload), &list); err != nil {
+			t.Fatalf("Failed to decode file list: %v", err)
+		}
+
+		var info *trackedFileInfo
+		for i := range list {
+			if list[i].Path == testFile {
+				info = &list[i]
+			}
+		}
+		if info == nil {
+			t.Fatalf("Expected %s in file list, got: %+v", testFile, list)
+		}
+
+		expectedURL := fmt.Sprintf("http://localhost:%d/?file=%s", port, testFile)
+		if info.URL != expectedURL {
+			t.Errorf("Expected URL %s, got %s", expectedURL, info.URL)
+		}
+		if info.Status != "ok" {
+			t.Errorf("Expected status ok, got %s", info.Status)
+		}
+		if info.RenderedAt.IsZero() {
+			t.Error("Expected render time to be set")
+		}
+	})
+
+	t.Run("Status", func(t *testing.T) {
+		socketPath, err := getSocketPath()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn, err := net.Dial("unix", socketPath)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer func() { _ = conn.Close() }()
+
+		if _, err := fmt.Fprintf(conn, "STATUS\n"); err != nil {
+			t.Fatal(err)
+		}
+
+		response, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		payload, found := strings.CutPrefix(strings.TrimSpace(response), "OK ")
+		if !found {
+			t.Fatalf("Expected OK response, got: %q", response)
+		}
+
+		var status daemonStatus
+		if err := json.Unmarshal([]byte(payload), &status); err != nil {
+			t.Fatalf("Failed to decode status: %v", err)
+		}
+
+		if status.PID != os.Getpid() {
+			t.Errorf("Expected PID %d, got %d", os.Getpid(), status.PID)
+		}
+		if status.Version != Version {
+			t.Errorf("Expected version %s, got %s", Version, status.Version)
+		}
+		if status.SocketPath != socketPath {
+			t.Errorf("Expected socket path %s, got %s", socketPath, status.SocketPath)
+		}
+		expectedLogPath := filepath.Join(tmpRuntimeDir, "lum", "lum.log")
+		if status.LogPath != expectedLogPath {
+			t.Errorf("Expected log path %s, got %s", expectedLogPath, status.LogPath)
+		}
+	})
+
+	t.Run("RemoveWithoutPath", func(t *testing.T) {
+		socketPath, err := getSocketPath()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn, err := net.Dial("unix", socketPath)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer func() { _ = conn.Close() }()
+
+		if _, err := fmt.Fprintf(conn, "REMOVE\n"); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectedResponse := "ERROR invalid command: expected 'REMOVE <path>'\n"
+		actualResponse := string(buf[:n])
+		if actualResponse != expectedResponse {
+			t.Errorf("Expected response:\n%q\nGot:\n%q", expectedResponse, actualResponse)
+		}
+	})
+
+	t.Run("RemoveUntrackedFile", func(t *testing.T) {
+		socketPath, err := getSocketPath()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn, err := net.Dial("unix", socketPath)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer func() { _ = conn.Close() }()
+
+		if _, err := fmt.Fprintf(conn, "REMOVE /nonexistent/file.md\n"); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectedResponse := "ERROR file not tracked: /nonexistent/file.md\n"
+		actualResponse := string(buf[:n])
+		if actualResponse != expectedResponse {
+			t.Errorf("Expected response:\n%q\nGot:\n%q", expectedResponse, actualResponse)
+		}
+	})
+
+	t.Run("SuccessfulRemove", func(t *testing.T) {
+		socketPath, err := getSocketPath()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn, err := net.Dial("unix", socketPath)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer func() { _ = conn.Close() }()
+
+		if _, err := fmt.Fprintf(conn, "REMOVE %s\n", testFile); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectedResponse := "OK\n"
+		actualResponse := string(buf[:n])
+		if actualResponse != expectedResponse {
+			t.Errorf("Expected response:\n%q\nGot:\n%q", expectedResponse, actualResponse)
+		}
+
+		filesLock.RLock()
+		_, exists := files[testFile]
+		filesLock.RUnlock()
+
+		if exists {
+			t.Error("File should not be tracked after REMOVE")
+		}
+	})
 }
 
 func TestTryAddToExistingServer(t *testing.T) {
@@ -379,9 +663,7 @@ func TestTryAddToExistingServer(t *testing.T) {
 		})
 
 		// Start server
-		go func() {
-			_ = startDaemon(port, testFile)
-		}()
+		startTestDaemon(t, &options{port: port}, documents{paths: []string{testFile}})
 
 		time.Sleep(500 * time.Millisecond)
 
@@ -425,8 +707,7 @@ func TestCleanupSocket(t *testing.T) {
 		})
 
 		// Start a socket
-		port := 16404
-		if err := startControlSocket(port); err != nil {
+		if err := startControlSocket(); err != nil {
 			t.Fatal(err)
 		}
 		time.Sleep(100 * time.Millisecond)
@@ -498,9 +779,7 @@ func TestControlSocketErrorHandling(t *testing.T) {
 			}
 		})
 
-		go func() {
-			_ = startDaemon(port, testFile)
-		}()
+		startTestDaemon(t, &options{port: port}, documents{paths: []string{testFile}})
 
 		time.Sleep(500 * time.Millisecond)
 
@@ -558,6 +837,39 @@ func TestControlSocketErrorHandling(t *testing.T) {
 	})
 }
 
+func TestGetLogPath(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldXDG := os.Getenv("XDG_RUNTIME_DIR")
+	if err := os.Setenv("XDG_RUNTIME_DIR", tmpDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if oldXDG != "" {
+			_ = os.Setenv("XDG_RUNTIME_DIR", oldXDG)
+		} else {
+			_ = os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+	})
+
+	logPath, err := getLogPath()
+	if err != nil {
+		t.Fatalf("getLogPath failed: %v", err)
+	}
+
+	socketPath, err := getSocketPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Log file lives next to the control socket
+	if filepath.Dir(logPath) != filepath.Dir(socketPath) {
+		t.Errorf("Expected log file next to socket, got %s and %s", logPath, socketPath)
+	}
+	if filepath.Base(logPath) != "lum.log" {
+		t.Errorf("Expected log file named lum.log, got %s", logPath)
+	}
+}
+
 func TestSetupLogFile(t *testing.T) {
 	t.Run("CreatesLogFile", func(t *testing.T) {
 		tmpDir := t.TempDir()
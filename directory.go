@@ -0,0 +1,412 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// removalCheckDelay is how long a file reported as removed from a tracked directory may take to reappear,
+	// as it does when an editor saves by replacing the file, before it stops being tracked
+	removalCheckDelay = 500 * time.Millisecond
+	// directoryDebounceDelay is the minimum time between two renders of a file in a tracked directory
+	directoryDebounceDelay = 100 * time.Millisecond
+)
+
+// defaultIncludes are the files tracked in a directory unless --include is given
+var defaultIncludes = []string{"*.md", "*.markdown"}
+
+// directoryFilter selects the files tracked in a directory. Patterns without a slash are matched against file
+// and directory names, the others against the path relative to the tracked directory.
+type directoryFilter struct {
+	// Include lists the files to track, defaultIncludes if empty
+	Include []string `json:"include,omitempty"`
+	// Exclude lists the files and directories to skip
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// validate checks that every pattern of the filter is well-formed
+func (f directoryFilter) validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		for segment := range strings.SplitSeq(pattern, "/") {
+			if _, err := path.Match(segment, ""); err != nil {
+				return fmt.Errorf("invalid pattern: %s", pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// trackedDir is a directory whose Markdown files are tracked. A single recursive watcher picks up files that are
+// created, changed and removed anywhere below it.
+type trackedDir struct {
+	path    string
+	filter  directoryFilter
+	addedAt time.Time
+	watcher *fsnotify.Watcher
+
+	// gitRoot is the root of the git work tree holding the directory, whose .gitignore files apply to it as well
+	gitRoot string
+
+	ignoreLock sync.Mutex
+	ignores    map[string][]ignoreRule
+}
+
+var (
+	dirs     = make(map[string]*trackedDir)
+	dirsLock sync.Mutex
+)
+
+// addPath adds a file, or every matching file below a directory
+func addPath(filePath string, filter directoryFilter) error {
+	info, err := os.Stat(filePath)
+	if err == nil && info.IsDir() {
+		return addDirectory(filePath, filter)
+	}
+
+	return addFile(filePath)
+}
+
+// addDirectory tracks every file below dirPath selected by filter and .gitignore, and watches the directory for
+// files being created and removed. If the directory is already tracked, this is a no-op and returns nil.
+func addDirectory(dirPath string, filter directoryFilter) error {
+	if err := filter.validate(); err != nil {
+		return err
+	}
+
+	dirsLock.Lock()
+	if _, exists := dirs[dirPath]; exists {
+		dirsLock.Unlock()
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		dirsLock.Unlock()
+		return fmt.Errorf("failed to start watching directory: %w", err)
+	}
+
+	dir := &trackedDir{
+		path:    dirPath,
+		filter:  filter,
+		addedAt: time.Now(),
+		watcher: watcher,
+		gitRoot: findGitRoot(dirPath),
+		ignores: make(map[string][]ignoreRule),
+	}
+	dirs[dirPath] = dir
+	dirsLock.Unlock()
+
+	if err := dir.scan(dirPath); err != nil {
+		dirsLock.Lock()
+		delete(dirs, dirPath)
+		dirsLock.Unlock()
+		if closeErr := watcher.Close(); closeErr != nil {
+			slog.Error("Failed to close watcher", "error", closeErr)
+		}
+		return fmt.Errorf("failed to scan directory: %w", err)
+	}
+
+	go dir.watch()
+
+	slog.Info("Tracking directory", "path", dirPath)
+	saveState()
+
+	return nil
+}
+
+// removeDirectory stops tracking a directory and the files that were tracked because they are in it
+func removeDirectory(dirPath string) error {
+	dirsLock.Lock()
+	dir, exists := dirs[dirPath]
+	if !exists {
+		dirsLock.Unlock()
+		return fmt.Errorf("directory not tracked: %s", dirPath)
+	}
+	delete(dirs, dirPath)
+	dirsLock.Unlock()
+
+	// Closing the watcher also ends the goroutine started by addDirectory
+	if err := dir.watcher.Close(); err != nil {
+		slog.Error("Failed to close watcher", "error", err)
+	}
+
+	for _, filePath := range dir.files(dirPath) {
+		_ = removeFile(filePath)
+	}
+
+	saveState()
+
+	return nil
+}
+
+// isTrackedDirectory reports whether dirPath is a tracked directory
+func isTrackedDirectory(dirPath string) bool {
+	dirsLock.Lock()
+	defer dirsLock.Unlock()
+
+	_, exists := dirs[dirPath]
+	return exists
+}
+
+// stopWatchingDirectories closes the watchers of all tracked directories. The directories stay tracked, so that
+// the state persisted for the next start is unaffected.
+func stopWatchingDirectories() {
+	dirsLock.Lock()
+	defer dirsLock.Unlock()
+
+	for _, dir := range dirs {
+		if err := dir.watcher.Close(); err != nil {
+			slog.Error("Failed to close watcher", "error", err)
+		}
+	}
+}
+
+// scan watches root and every directory below it, and adds the files that match
+func (d *trackedDir) scan(root string) error {
+	return filepath.WalkDir(root, func(entryPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// The root must be readable, unreadable directories below it are skipped
+			if entryPath == root {
+				return err
+			}
+			slog.Warn("Skipping unreadable path", "path", entryPath, "error", err)
+			return nil
+		}
+
+		if entry.IsDir() {
+			if d.skipDir(entryPath) {
+				return filepath.SkipDir
+			}
+			if err := d.watcher.Add(entryPath); err != nil {
+				if entryPath == root {
+					return err
+				}
+				slog.Warn("Failed to watch directory", "path", entryPath, "error", err)
+			}
+			return nil
+		}
+
+		if entry.Type().IsRegular() && d.matches(entryPath) {
+			// Files that were added on their own keep their own watcher
+			if standaloneFile(entryPath) {
+				return nil
+			}
+			if err := trackFile(entryPath, d.path); err != nil {
+				slog.Warn("Skipping file", "path", entryPath, "error", err)
+			}
+		}
+		return nil
+	})
+}
+
+// watch handles the events of the directory watcher until it is closed
+func (d *trackedDir) watch() {
+	lastReload := make(map[string]time.Time)
+
+	for {
+		select {
+		case event, ok := <-d.watcher.Events:
+			if !ok {
+				return
+			}
+			d.handleEvent(event, lastReload)
+		case err, ok := <-d.watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("Watcher error", "path", d.path, "error", err)
+		}
+	}
+}
+
+// standaloneFile reports whether filePath is tracked as a file of its own rather than as part of a directory
+func standaloneFile(filePath string) bool {
+	filesLock.RLock()
+	defer filesLock.RUnlock()
+
+	fileState, exists := files[filePath]
+	return exists && fileState.dir == ""
+}
+
+// handleEvent adds, renders or removes the file an event is about
+func (d *trackedDir) handleEvent(event fsnotify.Event, lastReload map[string]time.Time) {
+	if filepath.Base(event.Name) == ".gitignore" {
+		d.ignoreLock.Lock()
+		delete(d.ignores, filepath.Dir(event.Name))
+		d.ignoreLock.Unlock()
+		return
+	}
+
+	// Editors that save by replacing the file remove it for a moment, only files that stay gone are removed
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		time.AfterFunc(removalCheckDelay, func() { d.removeMissing(event.Name) })
+		return
+	}
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return
+	}
+
+	info, err := os.Stat(event.Name)
+	if err != nil {
+		return
+	}
+	if info.IsDir() {
+		if event.Has(fsnotify.Create) && !d.skipDir(event.Name) {
+			if err := d.scan(event.Name); err != nil {
+				slog.Error("Failed to scan directory", "path", event.Name, "error", err)
+			}
+		}
+		return
+	}
+	if !info.Mode().IsRegular() || !d.matches(event.Name) {
+		return
+	}
+
+	filesLock.RLock()
+	fileState, tracked := files[event.Name]
+	owned := tracked && fileState.dir == d.path
+	filesLock.RUnlock()
+
+	if !tracked {
+		if err := trackFile(event.Name, d.path); err != nil {
+			slog.Error("Failed to add file", "path", event.Name, "error", err)
+			return
+		}
+		slog.Info("Added new file in tracked directory", "path", event.Name)
+		return
+	}
+
+	// Files that were added on their own have their own watcher
+	if !owned {
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(lastReload[event.Name]) < directoryDebounceDelay {
+		return
+	}
+	lastReload[event.Name] = now
+
+	slog.Debug("File changed", "path", event.Name, "event", event.Op.String())
+	reloadFile(event.Name)
+}
+
+// removeMissing stops tracking the files of this directory at or below removedPath that no longer exist
+func (d *trackedDir) removeMissing(removedPath string) {
+	for _, filePath := range d.files(removedPath) {
+		if _, err := os.Stat(filePath); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err := removeFile(filePath); err == nil {
+			slog.Info("Removed deleted file in tracked directory", "path", filePath)
+		}
+	}
+}
+
+// files returns the files tracked because they are in this directory, at or below prefix
+func (d *trackedDir) files(prefix string) []string {
+	filesLock.RLock()
+	defer filesLock.RUnlock()
+
+	var paths []string
+	for filePath, fileState := range files {
+		if fileState.dir == d.path && isPathWithinDirectory(filePath, prefix) {
+			paths = append(paths, filePath)
+		}
+	}
+	return paths
+}
+
+// matches reports whether a file below the directory is selected by the filter and not ignored by git
+func (d *trackedDir) matches(filePath string) bool {
+	rel, err := filepath.Rel(d.path, filePath)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+
+	include := d.filter.Include
+	if len(include) == 0 {
+		include = defaultIncludes
+	}
+
+	if matchFilter(d.filter.Exclude, rel) || !matchFilter(include, rel) {
+		return false
+	}
+	return !isIgnored(d.ignoreRules(filepath.Dir(filePath)), filePath, false)
+}
+
+// skipDir reports whether a directory below the tracked directory is left out
+func (d *trackedDir) skipDir(dirPath string) bool {
+	if dirPath == d.path {
+		return false
+	}
+	if filepath.Base(dirPath) == ".git" {
+		return true
+	}
+
+	rel, err := filepath.Rel(d.path, dirPath)
+	if err != nil {
+		return true
+	}
+	if matchFilter(d.filter.Exclude, filepath.ToSlash(rel)) {
+		return true
+	}
+	return isIgnored(d.ignoreRules(filepath.Dir(dirPath)), dirPath, true)
+}
+
+// ignoreRules returns the rules of every .gitignore file that applies to the entries of dirPath, from the
+// root of the git work tree (or the tracked directory outside of one) down to dirPath
+func (d *trackedDir) ignoreRules(dirPath string) []ignoreRule {
+	top := d.path
+	if d.gitRoot != "" {
+		top = d.gitRoot
+	}
+
+	var chain []string
+	for dir := dirPath; ; dir = filepath.Dir(dir) {
+		chain = append(chain, dir)
+		if dir == top || filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	d.ignoreLock.Lock()
+	defer d.ignoreLock.Unlock()
+
+	var rules []ignoreRule
+	for i := len(chain) - 1; i >= 0; i-- {
+		dirRules, loaded := d.ignores[chain[i]]
+		if !loaded {
+			dirRules = parseGitignore(chain[i])
+			d.ignores[chain[i]] = dirRules
+		}
+		rules = append(rules, dirRules...)
+	}
+	return rules
+}
+
+// matchFilter reports whether the slash-separated path relative to a tracked directory matches any of patterns
+func matchFilter(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(rel)
+		}
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
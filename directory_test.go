@@ -0,0 +1,258 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dirFiles returns the sorted files tracked because they are in dir
+func dirFiles(dir string) []string {
+	filesLock.RLock()
+	defer filesLock.RUnlock()
+
+	var paths []string
+	for path, fileState := range files {
+		if fileState.dir == dir {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// waitForDirFiles waits until the files tracked in dir are expected
+func waitForDirFiles(t *testing.T, dir string, expected []string) {
+	t.Helper()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if slices.Equal(dirFiles(dir), expected) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("Expected tracked files %v, got %v", expected, dirFiles(dir))
+}
+
+func TestAddDirectory(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"a.md":           "# A",
+		"b.markdown":     "# B",
+		"notes.txt":      "notes",
+		"api/c.md":       "# C",
+		"build/d.md":     "# D",
+		"drafts/e.md":    "# E",
+		".git/f.md":      "# F",
+		".gitignore":     "build/\n",
+		"api/.gitignore": "*.gen.md\n",
+		"api/x.gen.md":   "# Generated",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := addDirectory(root, directoryFilter{Exclude: []string{"drafts"}}); err != nil {
+		t.Fatalf("Failed to add directory: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = removeDirectory(root)
+	})
+
+	expected := []string{
+		filepath.Join(root, "a.md"), filepath.Join(root, "api", "c.md"), filepath.Join(root, "b.markdown"),
+	}
+
+	t.Run("Scan", func(t *testing.T) {
+		if !isTrackedDirectory(root) {
+			t.Error("Expected the directory to be tracked")
+		}
+		if files := dirFiles(root); !slices.Equal(files, expected) {
+			t.Errorf("Expected %v, got %v", expected, files)
+		}
+	})
+
+	t.Run("NewFiles", func(t *testing.T) {
+		if err := os.WriteFile(filepath.Join(root, "api", "new.md"), []byte("# New"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(root, "guide"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, "guide", "g.md"), []byte("# Guide"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		// Ignored and excluded files stay untracked
+		if err := os.WriteFile(filepath.Join(root, "api", "y.gen.md"), []byte("# Generated"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		expected = append(expected, filepath.Join(root, "api", "new.md"), filepath.Join(root, "guide", "g.md"))
+		slices.Sort(expected)
+		waitForDirFiles(t, root, expected)
+	})
+
+	t.Run("ChangedFile", func(t *testing.T) {
+		changed := filepath.Join(root, "a.md")
+		if err := os.WriteFile(changed, []byte("# Changed"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		deadline := time.Now().Add(3 * time.Second)
+		for time.Now().Before(deadline) {
+			filesLock.RLock()
+			fileState := files[changed]
+			filesLock.RUnlock()
+
+			fileState.contentLock.RLock()
+			content := string(fileState.htmlContent)
+			fileState.contentLock.RUnlock()
+			if strings.Contains(content, "Changed") {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Error("Expected the changed file to be rendered again")
+	})
+
+	t.Run("DeletedFile", func(t *testing.T) {
+		if err := os.Remove(filepath.Join(root, "b.markdown")); err != nil {
+			t.Fatal(err)
+		}
+
+		expected = slices.DeleteFunc(expected, func(path string) bool {
+			return path == filepath.Join(root, "b.markdown")
+		})
+		waitForDirFiles(t, root, expected)
+	})
+
+	t.Run("RemoveDirectory", func(t *testing.T) {
+		if err := removeDirectory(root); err != nil {
+			t.Fatal(err)
+		}
+		if isTrackedDirectory(root) {
+			t.Error("Expected the directory to no longer be tracked")
+		}
+		if files := dirFiles(root); len(files) != 0 {
+			t.Errorf("Expected the directory's files to be removed, got %v", files)
+		}
+		if err := removeDirectory(root); err == nil {
+			t.Error("Expected an error when removing an untracked directory")
+		}
+	})
+}
+
+func TestAddDirectoryInclude(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a.md", "notes.txt", "docs/b.txt"} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("text"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := addDirectory(root, directoryFilter{Include: []string{"docs/*.txt"}}); err != nil {
+		t.Fatalf("Failed to add directory: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = removeDirectory(root)
+	})
+
+	expected := []string{filepath.Join(root, "docs", "b.txt")}
+	if files := dirFiles(root); !slices.Equal(files, expected) {
+		t.Errorf("Expected %v, got %v", expected, files)
+	}
+
+	if err := addDirectory(t.TempDir(), directoryFilter{Exclude: []string{"[invalid"}}); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+}
+
+func TestDirectoryFileOwnership(t *testing.T) {
+	path, err := getStatePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateLock.Lock()
+	statePath = path
+	stateLock.Unlock()
+	t.Cleanup(func() {
+		stateLock.Lock()
+		statePath = ""
+		stateLock.Unlock()
+	})
+
+	// standalone reports whether filePath is tracked on its own, with its own watcher
+	standalone := func(filePath string) bool {
+		filesLock.RLock()
+		defer filesLock.RUnlock()
+
+		fileState, exists := files[filePath]
+		return exists && fileState.dir == "" && fileState.watcher != nil
+	}
+
+	for _, directoryFirst := range []bool{true, false} {
+		name := "FileFirst"
+		if directoryFirst {
+			name = "DirectoryFirst"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			filePath := filepath.Join(root, "a.md")
+			if err := os.WriteFile(filePath, []byte("# A"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				_ = removeDirectory(root)
+				_ = removeFile(filePath)
+			})
+
+			add := []func() error{
+				func() error { return addFile(filePath) },
+				func() error { return addDirectory(root, directoryFilter{}) },
+			}
+			if directoryFirst {
+				slices.Reverse(add)
+			}
+			for _, fn := range add {
+				if err := fn(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if !standalone(filePath) {
+				t.Fatal("Expected the explicitly added file to be tracked on its own")
+			}
+
+			state, err := readStateFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state == nil || !slices.ContainsFunc(state.Files, func(file persistedFile) bool {
+				return file.Path == filePath
+			}) {
+				t.Errorf("Expected %s to be persisted, got %+v", filePath, state)
+			}
+
+			if err := removeDirectory(root); err != nil {
+				t.Fatal(err)
+			}
+			if !standalone(filePath) {
+				t.Error("Expected the file to stay tracked when its directory is removed")
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreRule is a single pattern of a .gitignore file
+type ignoreRule struct {
+	// base is the directory holding the .gitignore file
+	base    string
+	pattern string
+	negate  bool
+	dirOnly bool
+	// anchored patterns are matched against the path relative to base, the others against the name only
+	anchored bool
+}
+
+// parseGitignore reads the rules of the .gitignore file in dir. A directory without one has no rules.
+func parseGitignore(dir string) []ignoreRule {
+	file, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return nil
+	}
+	defer func() { _ = file.Close() }()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{base: dir}
+		if negated, found := strings.CutPrefix(line, "!"); found {
+			rule.negate = true
+			line = negated
+		}
+		// A leading backslash escapes a literal # or !
+		line = strings.TrimPrefix(line, `\`)
+		if trimmed, found := strings.CutSuffix(line, "/"); found {
+			rule.dirOnly = true
+			line = trimmed
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+// isIgnored reports whether path is ignored by rules, which are ordered from the outermost .gitignore file to
+// the innermost one. As in git, the last matching rule wins.
+func isIgnored(rules []ignoreRule, filePath string, isDir bool) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+
+		name := filepath.Base(filePath)
+		if rule.anchored {
+			rel, err := filepath.Rel(rule.base, filePath)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				continue
+			}
+			name = filepath.ToSlash(rel)
+		}
+
+		if matchGlob(rule.pattern, name) {
+			ignored = !rule.negate
+		}
+	}
+
+	return ignored
+}
+
+// matchGlob reports whether the slash-separated name matches pattern. Besides the syntax of path.Match,
+// a ** segment matches any number of directories.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// matchSegments matches the segments of a name against the segments of a pattern
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if matched, err := path.Match(pattern[0], name[0]); err != nil || !matched {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
+
+// findGitRoot returns the root of the git work tree dir belongs to, or "" if it is not in one
+func findGitRoot(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
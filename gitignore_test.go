@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"*.md", "README.md", true},
+		{"*.md", "notes.txt", false},
+		{"docs/*.md", "docs/a.md", true},
+		{"docs/*.md", "docs/api/a.md", false},
+		{"docs/**/*.md", "docs/a.md", true},
+		{"docs/**/*.md", "docs/api/v1/a.md", true},
+		{"**/drafts", "a/b/drafts", true},
+		{"drafts/**", "drafts/a.md", true},
+		{"drafts/**", "other/a.md", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.match {
+			t.Errorf("matchGlob(%q, %q) = %v, expected %v", tt.pattern, tt.name, got, tt.match)
+		}
+	}
+}
+
+func TestGitignore(t *testing.T) {
+	tmpDir := t.TempDir()
+	content := "# Build output\nbuild/\n*.tmp.md\n!keep.tmp.md\n/generated.md\ndocs/private\n"
+	if err := os.WriteFile(filepath.Join(tmpDir, ".gitignore"), []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rules := parseGitignore(tmpDir)
+	if len(rules) != 5 {
+		t.Fatalf("Expected 5 rules, got %+v", rules)
+	}
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"build", true, true},
+		{"sub/build", true, true},
+		{"build", false, false},
+		{"notes.tmp.md", false, true},
+		{"sub/notes.tmp.md", false, true},
+		{"keep.tmp.md", false, false},
+		{"generated.md", false, true},
+		{"sub/generated.md", false, false},
+		{"docs/private", true, true},
+		{"sub/docs/private", true, false},
+		{"README.md", false, false},
+	}
+
+	for _, tt := range tests {
+		if got := isIgnored(rules, filepath.Join(tmpDir, tt.path), tt.isDir); got != tt.ignored {
+			t.Errorf("isIgnored(%q, dir=%v) = %v, expected %v", tt.path, tt.isDir, got, tt.ignored)
+		}
+	}
+
+	if rules := parseGitignore(filepath.Join(tmpDir, "missing")); rules != nil {
+		t.Errorf("Expected no rules without a .gitignore file, got %+v", rules)
+	}
+}
+
+func TestFindGitRoot(t *testing.T) {
+	tmpDir := t.TempDir()
+	nested := filepath.Join(tmpDir, "docs", "api")
+	if err := os.MkdirAll(nested, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(tmpDir, ".git"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if root := findGitRoot(nested); root != tmpDir {
+		t.Errorf("Expected git root %s, got %s", tmpDir, root)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// A running server can hand its HTTP listener over to a new daemon process, so that the port stays bound
+// and browsers reconnect to the new process without noticing more than a reload.
+
+// listenerFDEnv holds the number of the inherited file descriptor of the HTTP listener
+const listenerFDEnv = "LUM_LISTENER_FD"
+
+// Server modes reported in daemonStatus.Mode
+const (
+	modeDaemon = "daemon"
+	modeOneOff = "one-off"
+)
+
+var (
+	// serverMode is the mode of the running server
+	serverMode string
+
+	// serverListener is the HTTP listener of the running server
+	serverListener net.Listener
+
+	// daemonOptions are the options the running daemon was started with, used to start its successor
+	daemonOptions *options
+
+	// handedOff is set once the HTTP listener and control socket belong to another process,
+	// which must then not be cleaned up on shutdown
+	handedOff    bool
+	handOverLock sync.Mutex
+
+	// takingOver is set when this daemon serves on the listener of a server handing over to it. The control
+	// socket of that server is still answering until it shuts down, and is replaced by this daemon's.
+	takingOver bool
+)
+
+// listenHTTP returns the listener passed by the parent process, if any, or binds addr
+func listenHTTP(addr string) (net.Listener, error) {
+	value := os.Getenv(listenerFDEnv)
+	if value == "" {
+		return net.Listen("tcp", addr)
+	}
+
+	// Processes started by the daemon must not inherit the variable
+	_ = os.Unsetenv(listenerFDEnv)
+	takingOver = true
+
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", listenerFDEnv, value)
+	}
+
+	file := os.NewFile(uintptr(fd), "listener")
+	defer func() { _ = file.Close() }()
+
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use inherited listener: %w", err)
+	}
+
+	slog.Info("Using inherited listener", "addr", listener.Addr())
+	return listener, nil
+}
+
+// promoteToDaemon hands the HTTP listener and tracked files of this one-off server over to a new daemon
+// process. Returns the URL of the daemon. The caller shuts this server down once the client has been answered.
+func promoteToDaemon() (string, *controlError) {
+	if serverMode != modeOneOff {
+		return "", &controlError{Code: errCodeNotPromotable, Message: "server is already running as a daemon"}
+	}
+
+	// The daemon picks up the tracked files from the state file, along with the files the instance served before.
+	// This server never persists its files itself.
+	path, err := getStatePath()
+	if err != nil {
+		return "", &controlError{Code: errCodeInternal, Message: err.Error()}
+	}
+	previous, readErr := os.ReadFile(path)
+	if err := mergeState(path); err != nil {
+		return "", &controlError{Code: errCodeInternal, Message: err.Error()}
+	}
+
+	// The daemon serves on the same address, with the same credentials
+	host, _, _ := net.SplitHostPort(serverAddr)
+	url, cerr := handOver(&options{
+		port:        serverPort(),
+		host:        host,
+		credentials: currentCredentials(),
+		instance:    instanceName,
+		logMaxSize:  logMaxSize,
+		logKeep:     logKeep,
+	})
+	if cerr != nil {
+		// Keep serving as a one-off server, and leave the instance's state as it was
+		if readErr == nil {
+			err = os.WriteFile(path, previous, 0o600)
+		} else {
+			err = os.Remove(path)
+		}
+		if err != nil {
+			slog.Error("Failed to restore state file", "error", err)
+		}
+		return "", cerr
+	}
+
+	return url, nil
+}
+
+// restartDaemon hands the HTTP listener and tracked files of this daemon over to a new daemon process
+// running the binary currently installed at the path this daemon was started from. Returns the URL of the
+// new daemon. The caller shuts this server down once the client has been answered.
+func restartDaemon() (string, *controlError) {
+	if serverMode != modeDaemon || daemonOptions == nil {
+		return "", &controlError{Code: errCodeNotRestartable, Message: "server is not running as a daemon"}
+	}
+	// The new daemon would run in the background, outside of the supervisor that started this one
+	if daemonOptions.foreground {
+		return "", &controlError{
+			Code:    errCodeNotRestartable,
+			Message: "daemon runs in the foreground, restart it through its supervisor",
+		}
+	}
+
+	// The state file is kept up to date, make sure it is complete before the new daemon reads it
+	saveState()
+
+	return handOver(daemonOptions)
+}
+
+// handOver starts a new daemon with opts that serves on this server's HTTP listener
+func handOver(opts *options) (string, *controlError) {
+	handOverLock.Lock()
+	defer handOverLock.Unlock()
+
+	if handedOff {
+		return "", &controlError{Code: errCodeInternal, Message: "server has already been handed over"}
+	}
+
+	tcpListener, ok := serverListener.(*net.TCPListener)
+	if !ok {
+		return "", &controlError{Code: errCodeInternal, Message: "server has no TCP listener to hand over"}
+	}
+
+	listenerFile, err := tcpListener.File()
+	if err != nil {
+		return "", &controlError{Code: errCodeInternal, Message: fmt.Sprintf("failed to get listener: %v", err)}
+	}
+	defer func() { _ = listenerFile.Close() }()
+
+	url, err := daemonize(opts, listenerFile)
+	if err != nil {
+		return "", &controlError{Code: errCodeInternal, Message: fmt.Sprintf("failed to start daemon: %v", err)}
+	}
+
+	handedOff = true
+	slog.Info("Handed over to the new daemon", "url", url)
+
+	return url, nil
+}
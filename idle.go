@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// lastActivity is the time (in Unix nanoseconds) of the last control command or of the last moment
+// a browser was viewing a page
+var lastActivity atomic.Int64
+
+// recordActivity marks the server as in use right now
+func recordActivity() {
+	lastActivity.Store(time.Now().UnixNano())
+}
+
+// idleSince returns the time of the last recorded activity
+func idleSince() time.Time {
+	return time.Unix(0, lastActivity.Load())
+}
+
+// totalViewerCount returns the number of SSE clients across all tracked files and the index page
+func totalViewerCount() int {
+	count := 0
+
+	filesLock.RLock()
+	for _, fileState := range files {
+		fileState.clientsLock.RLock()
+		count += len(fileState.sseClients)
+		fileState.clientsLock.RUnlock()
+	}
+	filesLock.RUnlock()
+
+	indexSSEClientsLock.RLock()
+	count += len(indexSSEClients)
+	indexSSEClientsLock.RUnlock()
+
+	return count
+}
+
+// watchIdle calls onIdle once the server has had no viewers and no control commands for timeout.
+// It returns after calling onIdle.
+func watchIdle(timeout time.Duration, onIdle func()) {
+	recordActivity()
+
+	// Check often enough that the server exits reasonably close to the timeout
+	interval := max(min(timeout/10, time.Second), 10*time.Millisecond)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// Open pages count as activity, the idle period starts when the last one is closed
+		if totalViewerCount() > 0 {
+			recordActivity()
+			continue
+		}
+
+		if idle := time.Since(idleSince()); idle >= timeout {
+			slog.Info("No viewers or control commands, shutting down", "idle", idle.Truncate(time.Second))
+			onIdle()
+			return
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchIdle(t *testing.T) {
+	t.Run("ExitsWhenIdle", func(t *testing.T) {
+		idle := make(chan struct{})
+		go watchIdle(100*time.Millisecond, func() { close(idle) })
+
+		select {
+		case <-idle:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected idle callback")
+		}
+	})
+
+	t.Run("ViewersKeepServerAlive", func(t *testing.T) {
+		viewer := make(chan string)
+		indexSSEClientsLock.Lock()
+		indexSSEClients[viewer] = true
+		indexSSEClientsLock.Unlock()
+
+		idle := make(chan struct{})
+		go watchIdle(100*time.Millisecond, func() { close(idle) })
+
+		select {
+		case <-idle:
+			t.Fatal("Expected no idle callback while a page is open")
+		case <-time.After(300 * time.Millisecond):
+		}
+
+		// The idle period starts once the last viewer is gone
+		indexSSEClientsLock.Lock()
+		delete(indexSSEClients, viewer)
+		indexSSEClientsLock.Unlock()
+
+		select {
+		case <-idle:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected idle callback after the viewer left")
+		}
+	})
+
+	t.Run("ControlCommandsKeepServerAlive", func(t *testing.T) {
+		idle := make(chan struct{})
+		go watchIdle(200*time.Millisecond, func() { close(idle) })
+
+		deadline := time.Now().Add(500 * time.Millisecond)
+		for time.Now().Before(deadline) {
+			recordActivity()
+			select {
+			case <-idle:
+				t.Fatal("Expected no idle callback while control commands arrive")
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// defaultInstance is the name of the instance used when --instance is not given.
+// It keeps its socket and log directly in the runtime directory, as lum always did.
+const defaultInstance = "default"
+
+// instanceName selects the daemon instance whose socket, log file and state file are used
+var instanceName = defaultInstance
+
+// validInstanceName matches instance names that are safe to use as a directory name
+var validInstanceName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// validateInstanceName checks that name can be used as an instance name
+func validateInstanceName(name string) error {
+	if !validInstanceName.MatchString(name) {
+		return fmt.Errorf(
+			"invalid instance name: %q (use letters, digits, '.', '_' and '-', starting with a letter or digit)", name,
+		)
+	}
+	return nil
+}
+
+// getInstanceDir returns the directory holding the control socket and log file of the selected instance
+func getInstanceDir() (string, error) {
+	return instanceDir(instanceName)
+}
+
+// instanceDir returns the directory of the named instance. Named instances live in instances/NAME
+// below the runtime directory. The directory is only created by createInstanceDir.
+func instanceDir(name string) (string, error) {
+	baseDir, err := getRuntimeDir()
+	if err != nil {
+		return "", err
+	}
+
+	if name == defaultInstance {
+		return baseDir, nil
+	}
+
+	return filepath.Join(baseDir, "instances", name), nil
+}
+
+// createInstanceDir creates the directory of the selected instance. Only servers create it, so that commands
+// for a mistyped instance name leave nothing behind.
+func createInstanceDir() error {
+	dir, err := getInstanceDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create instance directory: %w", err)
+	}
+
+	return nil
+}
+
+// instanceNames returns the names of the default instance and of every named instance that has
+// a directory in the runtime directory, sorted with the default instance first
+func instanceNames() ([]string, error) {
+	baseDir, err := getRuntimeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get runtime directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(baseDir, "instances"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read instances directory: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != defaultInstance && validInstanceName.MatchString(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	return append([]string{defaultInstance}, names...), nil
+}
+
+// listRunningInstances asks every instance with a control socket for its status.
+// Instances whose daemon is not reachable are skipped.
+func listRunningInstances() ([]daemonStatus, error) {
+	names, err := instanceNames()
+	if err != nil {
+		return nil, err
+	}
+
+	instances := []daemonStatus{}
+	for _, name := range names {
+		dir, err := instanceDir(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get directory of instance %s: %w", name, err)
+		}
+
+		status, err := getInstanceStatus(filepath.Join(dir, "control.sock"))
+		if err != nil {
+			continue
+		}
+		// Daemons predating named instances do not report their name
+		status.Instance = name
+
+		instances = append(instances, *status)
+	}
+
+	return instances, nil
+}
+
+// getInstanceStatus asks the daemon listening on socketPath to describe itself
+func getInstanceStatus(socketPath string) (*daemonStatus, error) {
+	client, err := dialControlAt(socketPath)
+	if err != nil {
+		return nil, err
+	}
+	defer closeControlClient(client)
+
+	var status daemonStatus
+	if err := client.call("status", nil, &status); err != nil {
+		return nil, fmt.Errorf("server error: %w", err)
+	}
+
+	return &status, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValidateInstanceName(t *testing.T) {
+	for _, name := range []string{"docs", "notes-2", "my_project", "v1.2", "A"} {
+		if err := validateInstanceName(name); err != nil {
+			t.Errorf("Expected %q to be valid, got: %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"", ".", "..", "../etc", "a/b", "-docs", "with space"} {
+		if err := validateInstanceName(name); err == nil {
+			t.Errorf("Expected %q to be rejected", name)
+		}
+	}
+}
+
+func TestInstancePaths(t *testing.T) {
+	tmpRuntimeDir := t.TempDir()
+	stateHome := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", stateHome)
+	oldXDG := os.Getenv("XDG_RUNTIME_DIR")
+	if err := os.Setenv("XDG_RUNTIME_DIR", tmpRuntimeDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		instanceName = defaultInstance
+		if oldXDG != "" {
+			_ = os.Setenv("XDG_RUNTIME_DIR", oldXDG)
+		} else {
+			_ = os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+	})
+
+	t.Run("DefaultInstance", func(t *testing.T) {
+		instanceName = defaultInstance
+
+		socketPath, err := getSocketPath()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := filepath.Join(tmpRuntimeDir, "lum", "control.sock"); socketPath != expected {
+			t.Errorf("Expected %s, got %s", expected, socketPath)
+		}
+	})
+
+	t.Run("NamedInstance", func(t *testing.T) {
+		instanceName = "docs"
+		defer func() { instanceName = defaultInstance }()
+
+		expectedDir := filepath.Join(tmpRuntimeDir, "lum", "instances", "docs")
+
+		socketPath, err := getSocketPath()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := filepath.Join(expectedDir, "control.sock"); socketPath != expected {
+			t.Errorf("Expected %s, got %s", expected, socketPath)
+		}
+
+		logPath, err := getLogPath()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := filepath.Join(expectedDir, "lum.log"); logPath != expected {
+			t.Errorf("Expected %s, got %s", expected, logPath)
+		}
+
+		statePath, err := getStatePath()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := filepath.Join(stateHome, "lum", "instances", "docs", "state.json"); statePath != expected {
+			t.Errorf("Expected %s, got %s", expected, statePath)
+		}
+
+		// Looking up paths must not leave a directory behind for a mistyped instance name
+		if _, err := os.Stat(expectedDir); !os.IsNotExist(err) {
+			t.Errorf("Expected the instance directory not to be created by path lookups, got %v", err)
+		}
+
+		if err := createInstanceDir(); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(expectedDir)
+		if err != nil {
+			t.Fatalf("Expected instance directory to be created: %v", err)
+		}
+		if info.Mode().Perm() != 0o700 {
+			t.Errorf("Expected instance directory mode 0700, got %o", info.Mode().Perm())
+		}
+	})
+
+	t.Run("InstanceNames", func(t *testing.T) {
+		if err := os.MkdirAll(filepath.Join(tmpRuntimeDir, "lum", "instances", "notes"), 0o700); err != nil {
+			t.Fatal(err)
+		}
+
+		names, err := instanceNames()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []string{defaultInstance, "docs", "notes"}
+		if len(names) != len(expected) {
+			t.Fatalf("Expected %v, got %v", expected, names)
+		}
+		for i := range expected {
+			if names[i] != expected[i] {
+				t.Errorf("Expected %v, got %v", expected, names)
+			}
+		}
+	})
+}
+
+func TestListRunningInstances(t *testing.T) {
+	tmpRuntimeDir := t.TempDir()
+	oldXDG := os.Getenv("XDG_RUNTIME_DIR")
+	if err := os.Setenv("XDG_RUNTIME_DIR", tmpRuntimeDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		instanceName = defaultInstance
+		if oldXDG != "" {
+			_ = os.Setenv("XDG_RUNTIME_DIR", oldXDG)
+		} else {
+			_ = os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+	})
+
+	// A stale instance directory without a daemon must not be listed
+	if err := os.MkdirAll(filepath.Join(tmpRuntimeDir, "lum", "instances", "stale"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	instanceName = "docs"
+	if err := startControlSocket(); err != nil {
+		t.Fatal(err)
+	}
+	instanceName = defaultInstance
+	time.Sleep(100 * time.Millisecond)
+
+	instances, err := listRunningInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(instances) != 1 {
+		t.Fatalf("Expected only the docs instance, got %+v", instances)
+	}
+	if instances[0].Instance != "docs" {
+		t.Errorf("Expected instance docs, got %s", instances[0].Instance)
+	}
+	if instances[0].PID != os.Getpid() {
+		t.Errorf("Expected PID %d, got %d", os.Getpid(), instances[0].PID)
+	}
+}
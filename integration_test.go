@@ -13,7 +13,7 @@ package main
 // 4. Tests verify behavior and coverage data is written to GOCOVERDIR
 //
 // Environment isolation:
-// - Each test uses a unique XDG_RUNTIME_DIR to avoid conflicts with live instances
+// - Each test uses a unique XDG_RUNTIME_DIR and XDG_STATE_HOME to avoid conflicts with live instances
 // - GOCOVERDIR is set to collect coverage data from the binary execution
 //
 // Usage:
@@ -22,10 +22,16 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -53,15 +59,36 @@ func getTestBinary(t *testing.T) string {
 	return absPath
 }
 
+// binaryRun holds the optional settings for running the test binary
+type binaryRun struct {
+	// runtimeDir holds the runtime and state files. Runs that talk to the same daemon share it,
+	// a unique directory is used if it is empty.
+	runtimeDir string
+	// env holds environment variables added to the isolated environment
+	env []string
+	// stdin is passed to the binary on its standard input
+	stdin string
+}
+
 // runBinary runs the test binary with the given arguments and returns the process.
-// The process runs in an isolated environment with a unique XDG_RUNTIME_DIR.
+// The process runs in an isolated environment with a unique XDG_RUNTIME_DIR and XDG_STATE_HOME.
+func runBinary(t *testing.T, binaryPath string, args ...string) *exec.Cmd {
+	t.Helper()
+
+	return binaryCommand(t, binaryPath, binaryRun{}, args...)
+}
+
+// binaryCommand returns the command running the test binary with the given arguments and settings.
 // Coverage data is written to the coverage directory (either from GOCOVERDIR env var
 // for CI, or a temp directory for local testing).
-func runBinary(t *testing.T, binaryPath string, args ...string) *exec.Cmd {
+func binaryCommand(t *testing.T, binaryPath string, run binaryRun, args ...string) *exec.Cmd {
 	t.Helper()
 
 	// Create isolated runtime directory
-	runtimeDir := t.TempDir()
+	runtimeDir := run.runtimeDir
+	if runtimeDir == "" {
+		runtimeDir = t.TempDir()
+	}
 
 	// Use GOCOVERDIR from environment if set (for CI), otherwise use temp dir
 	coverageDir := os.Getenv("GOCOVERDIR")
@@ -78,13 +105,38 @@ func runBinary(t *testing.T, binaryPath string, args ...string) *exec.Cmd {
 	testArgs = append(testArgs, args...)
 
 	cmd := exec.Command(binaryPath, testArgs...)
-	cmd.Env = append(os.Environ(),
-		fmt.Sprintf("XDG_RUNTIME_DIR=%s", runtimeDir),
-	)
+	cmd.Env = append(isolatedEnv(runtimeDir), run.env...)
+	if run.stdin != "" {
+		cmd.Stdin = strings.NewReader(run.stdin)
+	}
 
 	return cmd
 }
 
+// binaryOutput runs the test binary with the given arguments and settings and returns its combined output
+func binaryOutput(t *testing.T, binaryPath string, run binaryRun, args ...string) (string, error) {
+	t.Helper()
+
+	output, err := binaryCommand(t, binaryPath, run, args...).CombinedOutput()
+	return string(output), err
+}
+
+// binaryRunner returns a function that runs the test binary with the given arguments in runtimeDir
+// and returns its combined output
+func binaryRunner(t *testing.T, binaryPath, runtimeDir string) func(args ...string) (string, error) {
+	return func(args ...string) (string, error) {
+		return binaryOutput(t, binaryPath, binaryRun{runtimeDir: runtimeDir}, args...)
+	}
+}
+
+// isolatedEnv returns the environment for running the binary with its runtime and state files in runtimeDir
+func isolatedEnv(runtimeDir string) []string {
+	return append(os.Environ(),
+		fmt.Sprintf("XDG_RUNTIME_DIR=%s", runtimeDir),
+		fmt.Sprintf("XDG_STATE_HOME=%s", runtimeDir),
+	)
+}
+
 // TestRunMain is the entry point for running the application in the test binary.
 // When the test binary is executed with -test.run=^TestRunMain$, this test
 // will run, which in turn calls run().
@@ -189,7 +241,7 @@ func TestIntegrationOneOffMode(t *testing.T) {
 	}
 
 	// Verify URL format
-	expectedPrefix := "http://127.0.0.1:16500/?file="
+	expectedPrefix := "http://localhost:16500/?file="
 	if !strings.HasPrefix(url, expectedPrefix) {
 		t.Errorf("Expected URL to start with %s, got: %s", expectedPrefix, url)
 	}
@@ -216,6 +268,64 @@ func TestIntegrationOneOffMode(t *testing.T) {
 	}
 }
 
+// TestIntegrationOneOffVerbose tests that a one-off server logs to stderr with -v using a compiled binary.
+func TestIntegrationOneOffVerbose(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	binaryPath := getTestBinary(t)
+
+	testFile := filepath.Join(t.TempDir(), "test.md")
+	if err := os.WriteFile(testFile, []byte("# Test"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := runBinary(t, binaryPath, "-v", "--access-log", "--log-format", "json", "--port", "16515", testFile)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start binary: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+	})
+
+	// Wait until the server is up
+	if !bufio.NewScanner(stdout).Scan() {
+		t.Fatalf("Expected URL on stdout\nStderr: %s", stderr.String())
+	}
+
+	resp, err := http.Get("http://127.0.0.1:16515/?file=" + testFile)
+	if err != nil {
+		t.Fatalf("Failed to fetch page: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	// File changes are logged at debug level, which -v enables
+	if err := os.WriteFile(testFile, []byte("# Changed"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("Failed to send SIGTERM: %v", err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("Expected clean exit, got %v\nStderr: %s", err, stderr.String())
+	}
+
+	for _, expected := range []string{`"msg":"HTTP request"`, `"msg":"File changed"`, `"level":"DEBUG"`} {
+		if !strings.Contains(stderr.String(), expected) {
+			t.Errorf("Expected %s in stderr, got:\n%s", expected, stderr.String())
+		}
+	}
+}
+
 // TestIntegrationDaemonMode tests lum in daemon mode using a compiled binary with coverage.
 func TestIntegrationDaemonMode(t *testing.T) {
 	if testing.Short() {
@@ -232,24 +342,8 @@ func TestIntegrationDaemonMode(t *testing.T) {
 
 	runtimeDir := t.TempDir()
 
-	// Use GOCOVERDIR from environment if set (for CI), otherwise use temp dir
-	coverageDir := os.Getenv("GOCOVERDIR")
-	if coverageDir == "" {
-		coverageDir = t.TempDir()
-	}
-
 	// Start daemon with initial file
-	cmd := exec.Command(binaryPath,
-		"-test.run=^TestRunMain$",
-		fmt.Sprintf("-test.gocoverdir=%s", coverageDir),
-		"--",
-		"--daemon",
-		"--port", "16501",
-		testFile,
-	)
-	cmd.Env = append(os.Environ(),
-		fmt.Sprintf("XDG_RUNTIME_DIR=%s", runtimeDir),
-	)
+	cmd := binaryCommand(t, binaryPath, binaryRun{runtimeDir: runtimeDir}, "--daemon", "--port", "16501", testFile)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -319,17 +413,7 @@ func TestIntegrationDaemonMode(t *testing.T) {
 	}
 
 	// Stop daemon using --stop flag
-	stopCmd := exec.Command(binaryPath,
-		"-test.run=^TestRunMain$",
-		fmt.Sprintf("-test.gocoverdir=%s", coverageDir),
-		"--",
-		"--stop",
-	)
-	stopCmd.Env = append(os.Environ(),
-		fmt.Sprintf("XDG_RUNTIME_DIR=%s", runtimeDir),
-	)
-
-	if output, err := stopCmd.CombinedOutput(); err != nil {
+	if output, err := binaryOutput(t, binaryPath, binaryRun{runtimeDir: runtimeDir}, "--stop"); err != nil {
 		t.Errorf("Failed to stop daemon: %v\nOutput: %s", err, output)
 	}
 
@@ -347,6 +431,1070 @@ func TestIntegrationDaemonMode(t *testing.T) {
 	}
 }
 
+// TestIntegrationInstances tests running a named daemon instance with a compiled binary.
+func TestIntegrationInstances(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	binaryPath := getTestBinary(t)
+	runtimeDir := t.TempDir()
+
+	// lum runs the binary with the given arguments in the shared runtime directory
+	lum := binaryRunner(t, binaryPath, runtimeDir)
+
+	if output, err := lum("--instance", "docs", "--daemon", "--port", "16505"); err != nil {
+		t.Fatalf("Failed to start docs instance: %v\nOutput: %s", err, output)
+	}
+	t.Cleanup(func() {
+		_, _ = lum("--instance", "docs", "--stop")
+	})
+
+	socketPath := filepath.Join(runtimeDir, "lum", "instances", "docs", "control.sock")
+	if _, err := os.Stat(socketPath); err != nil {
+		t.Fatalf("Expected instance socket at %s: %v", socketPath, err)
+	}
+
+	// A running daemon keeps its port
+	testFile := filepath.Join(t.TempDir(), "test.md")
+	if err := os.WriteFile(testFile, []byte("# Test"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	output, err := lum("--instance", "docs", "--port", "16599", testFile)
+	if err != nil {
+		t.Fatalf("Failed to add file: %v\nOutput: %s", err, output)
+	}
+	if !strings.Contains(output, "--port 16599 is ignored") || !strings.Contains(output, "localhost:16505/?file=") {
+		t.Errorf("Expected a warning and the URL on the daemon's port, got:\n%s", output)
+	}
+	if output, err := lum("--instance", "docs", "--port", "16505", testFile); err != nil ||
+		strings.Contains(output, "Warning") {
+		t.Errorf("Expected no warning for the daemon's own port, got %v:\n%s", err, output)
+	}
+
+	output, err = lum("--list-instances")
+	if err != nil {
+		t.Fatalf("Failed to list instances: %v\nOutput: %s", err, output)
+	}
+	if !strings.Contains(output, "docs") || !strings.Contains(output, "127.0.0.1:16505") {
+		t.Errorf("Expected docs instance in list, got:\n%s", output)
+	}
+
+	// The default instance is not running, so commands without --instance must not reach the docs daemon
+	if output, err := lum("--status"); err == nil {
+		t.Errorf("Expected --status of the default instance to fail, got:\n%s", output)
+	}
+
+	// Commands for an instance that is not running leave no directory behind
+	for _, args := range [][]string{{"--list"}, {"--status"}, {"--stop"}, {"--logs"}} {
+		if output, err := lum(append([]string{"--instance", "dcos"}, args...)...); err == nil {
+			t.Errorf("Expected %v of a mistyped instance to fail, got:\n%s", args, output)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(runtimeDir, "lum", "instances", "dcos")); !os.IsNotExist(err) {
+		t.Errorf("Expected no directory for the mistyped instance, got %v", err)
+	}
+
+	if output, err := lum("--instance", "docs", "--stop"); err != nil {
+		t.Errorf("Failed to stop docs instance: %v\nOutput: %s", err, output)
+	}
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Errorf("Expected instance socket to be removed after stop, got: %v", err)
+	}
+}
+
+// TestIntegrationOneOffPromotion tests adding files to a one-off server and promoting it to a daemon.
+func TestIntegrationOneOffPromotion(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	binaryPath := getTestBinary(t)
+	runtimeDir := t.TempDir()
+	tmpDir := t.TempDir()
+
+	// command prepares the binary with the given arguments in the shared runtime directory
+	command := func(args ...string) *exec.Cmd {
+		return binaryCommand(t, binaryPath, binaryRun{runtimeDir: runtimeDir}, args...)
+	}
+
+	firstFile := filepath.Join(tmpDir, "first.md")
+	secondFile := filepath.Join(tmpDir, "second.md")
+	persistedFile := filepath.Join(tmpDir, "persisted.md")
+	for _, file := range []string{firstFile, secondFile, persistedFile} {
+		if err := os.WriteFile(file, []byte("# Test"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The instance served a file before, which the promoted daemon keeps
+	stateDir := filepath.Join(runtimeDir, "lum")
+	if err := os.MkdirAll(stateDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	state := fmt.Sprintf(`{"files":[{"path":%q,"added_at":"2024-01-02T03:04:05Z"}]}`, persistedFile)
+	if err := os.WriteFile(filepath.Join(stateDir, "state.json"), []byte(state), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	oneOff := command("--port", "16507", firstFile)
+	stdout, err := oneOff.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := oneOff.Start(); err != nil {
+		t.Fatalf("Failed to start one-off server: %v", err)
+	}
+	defer func() {
+		_ = oneOff.Process.Kill()
+		_ = oneOff.Wait()
+	}()
+
+	// The one-off server prints its URL once it is serving
+	if _, err := bufio.NewReader(stdout).ReadString('\n'); err != nil {
+		t.Fatalf("One-off server did not print its URL: %v", err)
+	}
+	go func() { _, _ = io.Copy(io.Discard, stdout) }()
+
+	output, err := command(secondFile).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Failed to add file to one-off server: %v\nOutput: %s", err, output)
+	}
+	if !strings.Contains(string(output), "http://localhost:16507/?file="+secondFile) {
+		t.Errorf("Expected URL of the added file, got:\n%s", output)
+	}
+
+	output, err = command("--daemon").CombinedOutput()
+	if err != nil {
+		t.Fatalf("Failed to promote one-off server: %v\nOutput: %s", err, output)
+	}
+	t.Cleanup(func() {
+		_ = command("--stop").Run()
+	})
+
+	// The one-off server exits once the daemon has taken over
+	done := make(chan error, 1)
+	go func() { done <- oneOff.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected one-off server to exit cleanly, got: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("One-off server did not exit after promotion")
+	}
+
+	output, err = command("--status", "--json").Output()
+	if err != nil {
+		t.Fatalf("Failed to get daemon status: %v", err)
+	}
+	if !strings.Contains(string(output), `"mode": "daemon"`) {
+		t.Errorf("Expected the promoted server to run as a daemon, got:\n%s", output)
+	}
+
+	output, err = command("--list").Output()
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	for _, file := range []string{firstFile, secondFile, persistedFile} {
+		if !strings.Contains(string(output), file) {
+			t.Errorf("Expected %s to be served by the daemon, got:\n%s", file, output)
+		}
+	}
+
+	resp, err := http.Get("http://127.0.0.1:16507/?file=" + secondFile)
+	if err != nil {
+		t.Fatalf("Daemon is not serving on the one-off port: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+}
+
+// TestIntegrationIdleTimeout tests that an idle daemon exits on its own with a compiled binary.
+func TestIntegrationIdleTimeout(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	binaryPath := getTestBinary(t)
+	runtimeDir := t.TempDir()
+
+	lum := binaryRunner(t, binaryPath, runtimeDir)
+	if output, err := lum("--daemon", "--port", "16508", "--idle-timeout", "500ms"); err != nil {
+		t.Fatalf("Failed to start daemon: %v\nOutput: %s", err, output)
+	}
+
+	socketPath := filepath.Join(runtimeDir, "lum", "control.sock")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(socketPath); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Idle daemon did not exit")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	logData, err := os.ReadFile(filepath.Join(runtimeDir, "lum", "lum.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(logData), "Daemon stopped") {
+		t.Errorf("Expected daemon to shut down cleanly, log:\n%s", logData)
+	}
+}
+
+// TestIntegrationRestart tests restarting a daemon in place with a compiled binary.
+func TestIntegrationRestart(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	binaryPath := getTestBinary(t)
+	runtimeDir := t.TempDir()
+
+	// lum runs the binary with the given arguments in the shared runtime directory
+	lum := binaryRunner(t, binaryPath, runtimeDir)
+
+	testFile := filepath.Join(t.TempDir(), "test.md")
+	if err := os.WriteFile(testFile, []byte("# Test"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if output, err := lum("--daemon", "--port", "16509", testFile); err != nil {
+		t.Fatalf("Failed to start daemon: %v\nOutput: %s", err, output)
+	}
+	t.Cleanup(func() {
+		_, _ = lum("--stop")
+	})
+
+	statusBefore, err := lum("--status", "--json")
+	if err != nil {
+		t.Fatalf("Failed to get daemon status: %v", err)
+	}
+
+	// Keep a page open across the restart
+	resp, err := http.Get("http://127.0.0.1:16509/events?file=" + testFile)
+	if err != nil {
+		t.Fatalf("Failed to connect to SSE endpoint: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	events := bufio.NewReader(resp.Body)
+
+	output, err := lum("--restart")
+	if err != nil {
+		t.Fatalf("Failed to restart daemon: %v\nOutput: %s", err, output)
+	}
+	if !strings.HasPrefix(output, "Restarted daemon (PID ") {
+		t.Errorf("Unexpected restart output: %s", output)
+	}
+
+	// The old daemon tells open pages that it is going away
+	line, err := events.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read SSE event: %v", err)
+	}
+	if line != "data: server-stopping\n" {
+		t.Errorf("Expected server-stopping event, got %q", line)
+	}
+
+	statusAfter, err := lum("--status", "--json")
+	if err != nil {
+		t.Fatalf("Failed to get status of restarted daemon: %v", err)
+	}
+	if statusAfter == statusBefore {
+		t.Error("Expected a new daemon process after restart")
+	}
+
+	output, err = lum("--list")
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	if !strings.Contains(output, testFile) {
+		t.Errorf("Expected restarted daemon to keep serving %s, got:\n%s", testFile, output)
+	}
+
+	page, err := http.Get("http://127.0.0.1:16509/?file=" + testFile)
+	if err != nil {
+		t.Fatalf("Restarted daemon is not serving: %v", err)
+	}
+	_ = page.Body.Close()
+	if page.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", page.StatusCode)
+	}
+}
+
+// TestIntegrationForegroundDaemon tests running the daemon in the foreground with a compiled binary.
+func TestIntegrationForegroundDaemon(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	binaryPath := getTestBinary(t)
+	runtimeDir := t.TempDir()
+
+	// lumCommand prepares the binary with the given arguments in the shared runtime directory
+	lumCommand := func(args ...string) *exec.Cmd {
+		return binaryCommand(t, binaryPath, binaryRun{runtimeDir: runtimeDir}, args...)
+	}
+
+	daemon := lumCommand("--daemon", "--foreground", "--port", "16512")
+	var stderr strings.Builder
+	daemon.Stderr = &stderr
+	if err := daemon.Start(); err != nil {
+		t.Fatalf("Failed to start daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = daemon.Process.Kill()
+	})
+
+	// Wait for the control socket
+	socketPath := filepath.Join(runtimeDir, "lum", "control.sock")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(socketPath); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Daemon did not open its control socket\nStderr: %s", stderr.String())
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	testFile := filepath.Join(t.TempDir(), "test.md")
+	if err := os.WriteFile(testFile, []byte("# Test"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files are added to the daemon in the foreground like to any other daemon
+	output, err := lumCommand(testFile).Output()
+	if err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	if !strings.Contains(string(output), ":16512/") {
+		t.Errorf("Expected URL of the daemon, got %s", output)
+	}
+
+	if err := daemon.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("Failed to send SIGTERM: %v", err)
+	}
+	if err := daemon.Wait(); err != nil {
+		t.Errorf("Expected daemon to exit cleanly on SIGTERM, got %v\nStderr: %s", err, stderr.String())
+	}
+
+	expectedLines := []string{"Daemon starting in the foreground", "Added file via control socket", "Daemon stopped"}
+	for _, expected := range expectedLines {
+		if !strings.Contains(stderr.String(), expected) {
+			t.Errorf("Expected %q in stderr, got:\n%s", expected, stderr.String())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(runtimeDir, "lum", "lum.log")); !os.IsNotExist(err) {
+		t.Error("Expected no log file for a daemon in the foreground")
+	}
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Error("Expected control socket to be removed on shutdown")
+	}
+}
+
+// TestIntegrationSocketActivation tests a daemon started by systemd socket activation with a compiled binary.
+func TestIntegrationSocketActivation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	binaryPath := getTestBinary(t)
+	runtimeDir := t.TempDir()
+
+	// Listen on both sockets like systemd does
+	httpListener, err := net.Listen("tcp", "127.0.0.1:16513")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = httpListener.Close() }()
+
+	if err := os.Mkdir(filepath.Join(runtimeDir, "lum"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	socketPath := filepath.Join(runtimeDir, "lum", "control.sock")
+	controlListener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	controlListener.(*net.UnixListener).SetUnlinkOnClose(false)
+	defer func() { _ = controlListener.Close() }()
+
+	httpFile, err := httpListener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = httpFile.Close() }()
+	controlFile, err := controlListener.(*net.UnixListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = controlFile.Close() }()
+
+	// LISTEN_PID must name the daemon process, the shell execs the binary under its own PID
+	binary := binaryCommand(t, binaryPath, binaryRun{
+		runtimeDir: runtimeDir,
+		env:        []string{"LISTEN_FDS=2", "LISTEN_FDNAMES=http:control"},
+	}, "--daemon")
+	daemon := exec.Command("sh", append([]string{"-c", `LISTEN_PID=$$ exec "$@"`, "sh"}, binary.Args...)...)
+	daemon.Env = binary.Env
+	daemon.ExtraFiles = []*os.File{httpFile, controlFile}
+	var stderr strings.Builder
+	daemon.Stderr = &stderr
+	if err := daemon.Start(); err != nil {
+		t.Fatalf("Failed to start daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = daemon.Process.Kill()
+	})
+
+	testFile := filepath.Join(t.TempDir(), "test.md")
+	if err := os.WriteFile(testFile, []byte("# Test"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first connection to the control socket is served once the daemon is up
+	output, err := binaryCommand(t, binaryPath, binaryRun{runtimeDir: runtimeDir}, testFile).Output()
+	if err != nil {
+		t.Fatalf("Failed to add file: %v\nStderr: %s", err, stderr.String())
+	}
+	if !strings.Contains(string(output), ":16513/") {
+		t.Errorf("Expected URL on the activated port, got %s", output)
+	}
+
+	resp, err := http.Get("http://127.0.0.1:16513/?file=" + testFile)
+	if err != nil {
+		t.Fatalf("Failed to fetch page: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	if err := daemon.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("Failed to send SIGTERM: %v", err)
+	}
+	if err := daemon.Wait(); err != nil {
+		t.Errorf("Expected daemon to exit cleanly on SIGTERM, got %v\nStderr: %s", err, stderr.String())
+	}
+
+	if !strings.Contains(stderr.String(), "passed by systemd") {
+		t.Errorf("Expected daemon to use the passed sockets, got:\n%s", stderr.String())
+	}
+	if _, err := os.Stat(socketPath); err != nil {
+		t.Error("Expected the control socket owned by systemd to be kept")
+	}
+}
+
+// TestIntegrationAutomaticPort tests a daemon bound to any free port with a compiled binary.
+func TestIntegrationAutomaticPort(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	binaryPath := getTestBinary(t)
+	runtimeDir := t.TempDir()
+
+	// lum runs the binary with the given arguments in the shared runtime directory
+	lum := func(args ...string) (string, error) {
+		output, err := binaryCommand(t, binaryPath, binaryRun{runtimeDir: runtimeDir}, args...).Output()
+		// Only the first line, the test binary adds its own summary
+		line, _, _ := strings.Cut(string(output), "\n")
+		return line, err
+	}
+
+	output, err := lum("--daemon", "--port", "auto")
+	if err != nil {
+		t.Fatalf("Failed to start daemon: %v\nOutput: %s", err, output)
+	}
+	t.Cleanup(func() {
+		_, _ = lum("--stop")
+	})
+
+	daemonURL, err := url.Parse(output)
+	if err != nil || daemonURL.Port() == "" || daemonURL.Port() == "0" {
+		t.Fatalf("Expected URL with the bound port, got %q", output)
+	}
+	port := daemonURL.Port()
+
+	testFile := filepath.Join(t.TempDir(), "test.md")
+	if err := os.WriteFile(testFile, []byte("# Test"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	output, err = lum(testFile)
+	if err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	if !strings.Contains(output, ":"+port+"/") {
+		t.Errorf("Expected URL on port %s, got %s", port, output)
+	}
+
+	stateData, err := os.ReadFile(filepath.Join(runtimeDir, "lum", "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(stateData), `"port": `+port) {
+		t.Errorf("Expected port %s in state, got:\n%s", port, stateData)
+	}
+
+	// The next daemon picks the same port again
+	if output, err := lum("--stop"); err != nil {
+		t.Fatalf("Failed to stop daemon: %v\nOutput: %s", err, output)
+	}
+	output, err = lum("--daemon", "--port", "0")
+	if err != nil {
+		t.Fatalf("Failed to start daemon again: %v\nOutput: %s", err, output)
+	}
+	if !strings.Contains(output, ":"+port+"/") {
+		t.Errorf("Expected daemon to reuse port %s, got %s", port, output)
+	}
+}
+
+// TestIntegrationLogs tests printing and rotating the daemon log with a compiled binary.
+func TestIntegrationLogs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	binaryPath := getTestBinary(t)
+	runtimeDir := t.TempDir()
+
+	// lum runs the binary with the given arguments in the shared runtime directory
+	lum := binaryRunner(t, binaryPath, runtimeDir)
+
+	if _, err := lum("--logs"); err == nil {
+		t.Error("Expected error when there is no log yet")
+	}
+
+	output, err := lum("--daemon", "--port", "16516", "--access-log", "--log-max-size", "2K", "--log-keep", "1")
+	if err != nil {
+		t.Fatalf("Failed to start daemon: %v\nOutput: %s", err, output)
+	}
+	t.Cleanup(func() {
+		_, _ = lum("--stop")
+	})
+
+	// Every request adds an access log record, enough of them rotate the log
+	for range 30 {
+		resp, err := http.Get("http://127.0.0.1:16516/")
+		if err != nil {
+			t.Fatalf("Failed to fetch index: %v", err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	logPath := filepath.Join(runtimeDir, "lum", "lum.log")
+	if _, err := os.Stat(logPath + ".1"); err != nil {
+		t.Errorf("Expected the log to be rotated: %v", err)
+	}
+	if _, err := os.Stat(logPath + ".2"); !os.IsNotExist(err) {
+		t.Error("Expected only one rotated log to be kept")
+	}
+
+	output, err = lum("--logs")
+	if err != nil {
+		t.Fatalf("Failed to print logs: %v", err)
+	}
+	if !strings.Contains(output, `msg="HTTP request"`) {
+		t.Errorf("Expected access log records, got:\n%s", output)
+	}
+}
+
+// TestIntegrationStaleSocket tests that the socket of a killed daemon is cleaned up with a compiled binary.
+func TestIntegrationMultipleFiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	binaryPath := getTestBinary(t)
+	runtimeDir := t.TempDir()
+	docsDir := t.TempDir()
+
+	for _, name := range []string{"a.md", "b.md", "README.md"} {
+		if err := os.WriteFile(filepath.Join(docsDir, name), []byte("# Test"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// lum runs the binary with the given arguments in the shared runtime directory, without a shell expanding
+	// patterns
+	lum := func(args ...string) (string, string, error) {
+		cmd := binaryCommand(t, binaryPath, binaryRun{runtimeDir: runtimeDir}, args...)
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		return string(output), stderr.String(), err
+	}
+
+	output, stderr, err := lum("--daemon", "--port", "16517", filepath.Join(docsDir, "a.md"))
+	if err != nil {
+		t.Fatalf("Failed to start daemon: %v\nStderr: %s", err, stderr)
+	}
+	t.Cleanup(func() {
+		_, _, _ = lum("--stop")
+	})
+	if !strings.Contains(output, "http://localhost:16517/?file="+filepath.Join(docsDir, "a.md")) {
+		t.Errorf("Expected URL of the initial file, got:\n%s", output)
+	}
+
+	missing := filepath.Join(docsDir, "missing.md")
+	output, stderr, err = lum(filepath.Join(docsDir, "*.md"), missing)
+	if err == nil {
+		t.Error("Expected a non-zero exit status when a file fails")
+	}
+	for _, name := range []string{"README.md", "a.md", "b.md"} {
+		if !strings.Contains(output, "http://localhost:16517/?file="+filepath.Join(docsDir, name)) {
+			t.Errorf("Expected URL of %s, got:\n%s", name, output)
+		}
+	}
+	if !strings.Contains(stderr, "Failed to add "+missing) {
+		t.Errorf("Expected the missing file to be reported, got:\n%s", stderr)
+	}
+
+	output, _, err = lum("--list")
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	if !strings.Contains(output, filepath.Join(docsDir, "b.md")) {
+		t.Errorf("Expected files matched by the pattern to be served, got:\n%s", output)
+	}
+}
+
+func TestIntegrationDirectory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	binaryPath := getTestBinary(t)
+	runtimeDir := t.TempDir()
+	docsDir := t.TempDir()
+
+	for name, content := range map[string]string{"a.md": "# A", "drafts/b.md": "# B", "notes.txt": "notes"} {
+		path := filepath.Join(docsDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// lum runs the binary with the given arguments in the shared runtime directory
+	lum := binaryRunner(t, binaryPath, runtimeDir)
+
+	output, err := lum("--daemon", "--port", "16518")
+	if err != nil {
+		t.Fatalf("Failed to start daemon: %v\nOutput: %s", err, output)
+	}
+	t.Cleanup(func() {
+		_, _ = lum("--stop")
+	})
+
+	output, err = lum(docsDir, "--exclude", "drafts")
+	if err != nil {
+		t.Fatalf("Failed to add directory: %v\nOutput: %s", err, output)
+	}
+	if !strings.HasPrefix(output, "http://localhost:16518/\n") {
+		t.Errorf("Expected the index URL for a directory, got:\n%s", output)
+	}
+
+	// Files created later are picked up by the daemon
+	newFile := filepath.Join(docsDir, "guide", "new.md")
+	if err := os.MkdirAll(filepath.Dir(newFile), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(newFile, []byte("# New"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		output, err = lum("--list")
+		if err != nil {
+			t.Fatalf("Failed to list files: %v", err)
+		}
+		if strings.Contains(output, newFile) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if !strings.Contains(output, filepath.Join(docsDir, "a.md")) || !strings.Contains(output, newFile) {
+		t.Errorf("Expected the directory's Markdown files to be served, got:\n%s", output)
+	}
+	if strings.Contains(output, "drafts") || strings.Contains(output, "notes.txt") {
+		t.Errorf("Expected excluded and non-Markdown files to be skipped, got:\n%s", output)
+	}
+
+	if output, err := lum("--remove", docsDir); err != nil {
+		t.Fatalf("Failed to remove directory: %v\nOutput: %s", err, output)
+	}
+	output, err = lum("--list")
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	if strings.Contains(output, docsDir) {
+		t.Errorf("Expected the directory's files to be removed, got:\n%s", output)
+	}
+}
+
+func TestIntegrationAPI(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	binaryPath := getTestBinary(t)
+	runtimeDir := t.TempDir()
+	testFile := filepath.Join(t.TempDir(), "api.md")
+	if err := os.WriteFile(testFile, []byte("# Added Over HTTP"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// lum runs the binary with the given arguments in the shared runtime directory
+	lum := binaryRunner(t, binaryPath, runtimeDir)
+
+	output, err := lum("--daemon", "--port", "16520")
+	if err != nil {
+		t.Fatalf("Failed to start daemon: %v\nOutput: %s", err, output)
+	}
+	t.Cleanup(func() {
+		_, _ = lum("--stop")
+	})
+
+	tokenPath := filepath.Join(runtimeDir, "lum", "api-token")
+	output, err = lum("--status")
+	if err != nil || !strings.Contains(output, tokenPath) {
+		t.Errorf("Expected the status to show the API token at %s, got:\n%s", tokenPath, output)
+	}
+	token, err := os.ReadFile(tokenPath)
+	if err != nil {
+		t.Fatalf("Expected the daemon to write its API token: %v", err)
+	}
+
+	// api sends an authorized API request and returns the status code and body of the response
+	api := func(method, path, body string) (int, string) {
+		req, err := http.NewRequest(method, "http://localhost:16520"+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	resp, err := http.Get("http://localhost:16520/api/files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected requests without the token to be rejected, got %d", resp.StatusCode)
+	}
+
+	if status, body := api("POST", "/api/files", fmt.Sprintf(`{"path":%q}`, testFile)); status != http.StatusCreated {
+		t.Fatalf("Expected the file to be added, got %d: %s", status, body)
+	}
+	if output, err := lum("--list"); err != nil || !strings.Contains(output, testFile) {
+		t.Errorf("Expected the file added over HTTP to be listed, got:\n%s", output)
+	}
+
+	status, body := api("GET", "/api/files/"+url.PathEscape(testFile), "")
+	if status != http.StatusOK || !strings.Contains(body, "Added Over HTTP") {
+		t.Errorf("Expected the rendered file, got %d: %s", status, body)
+	}
+
+	if status, body := api("DELETE", "/api/files/"+url.PathEscape(testFile), ""); status != http.StatusNoContent {
+		t.Errorf("Expected the file to be removed, got %d: %s", status, body)
+	}
+	if status, body := api("GET", "/api/files", ""); status != http.StatusOK || strings.Contains(body, testFile) {
+		t.Errorf("Expected an empty list, got %d: %s", status, body)
+	}
+
+	if output, err := lum("--stop"); err != nil {
+		t.Fatalf("Failed to stop daemon: %v\nOutput: %s", err, output)
+	}
+	if _, err := os.Stat(tokenPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the API token to be removed when the daemon stops, got %v", err)
+	}
+}
+
+func TestIntegrationHost(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	binaryPath := getTestBinary(t)
+	runtimeDir := t.TempDir()
+	testFile := filepath.Join(t.TempDir(), "shared.md")
+	if err := os.WriteFile(testFile, []byte("# Shared on the Network"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// lum runs the binary with the given arguments in the shared runtime directory
+	lum := binaryRunner(t, binaryPath, runtimeDir)
+
+	output, err := lum("--daemon", "--host", "0.0.0.0", "--port", "16521")
+	if err == nil || !strings.Contains(output, "--token") {
+		t.Fatalf("Expected the daemon to refuse binding all interfaces without credentials, got %v:\n%s", err, output)
+	}
+
+	withToken := binaryRun{runtimeDir: runtimeDir, env: []string{"LUM_TOKEN=s3cret"}}
+	output, err = binaryOutput(t, binaryPath, withToken, "--daemon", "--host", "0.0.0.0", "--port", "16521", testFile)
+	if err != nil {
+		t.Fatalf("Failed to start daemon: %v\nOutput: %s", err, output)
+	}
+	t.Cleanup(func() {
+		_, _ = lum("--stop")
+	})
+	if !strings.Contains(output, "http://localhost:16521/?file="+testFile) {
+		t.Errorf("Expected the local URL of the file, got:\n%s", output)
+	}
+
+	fileURL := "http://localhost:16521/?file=" + url.QueryEscape(testFile)
+	resp, err := http.Get(fileURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected requests without the token to be rejected, got %d", resp.StatusCode)
+	}
+
+	// The client follows the redirect that moves the token into a cookie
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Jar: jar}
+	resp, err = client.Get(fileURL + "&token=s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "Shared on the Network") {
+		t.Errorf("Expected the file with a valid token, got %d", resp.StatusCode)
+	}
+	if strings.Contains(resp.Request.URL.RawQuery, "token") {
+		t.Errorf("Expected the token to be dropped from the URL, got %s", resp.Request.URL)
+	}
+
+	// Commands over the control socket need no token
+	if output, err := lum("--list"); err != nil || !strings.Contains(output, testFile) {
+		t.Errorf("Expected the file to be listed, got %v:\n%s", err, output)
+	}
+}
+
+func TestIntegrationStdin(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	binaryPath := getTestBinary(t)
+	runtimeDir := t.TempDir()
+
+	// lum runs the binary with the given arguments in the shared runtime directory
+	lum := binaryRunner(t, binaryPath, runtimeDir)
+
+	output, err := lum("--daemon", "--port", "16519")
+	if err != nil {
+		t.Fatalf("Failed to start daemon: %v\nOutput: %s", err, output)
+	}
+	t.Cleanup(func() {
+		_, _ = lum("--stop")
+	})
+
+	output, err = binaryOutput(t, binaryPath, binaryRun{runtimeDir: runtimeDir, stdin: "# Piped Document\n"}, "-")
+	if err != nil {
+		t.Fatalf("Failed to add stdin: %v\nOutput: %s", err, output)
+	}
+	pageURL, _, _ := strings.Cut(output, "\n")
+	id := strings.TrimPrefix(pageURL, "http://localhost:16519/?file=")
+	if !strings.HasPrefix(id, stdinIDPrefix) {
+		t.Fatalf("Expected the URL of a stdin document, got:\n%s", output)
+	}
+
+	resp, err := http.Get(pageURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if !strings.Contains(string(body), "Piped Document") {
+		t.Errorf("Expected the piped Markdown to be rendered, got:\n%s", body)
+	}
+
+	resp, err = http.Get("http://localhost:16519/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if !strings.Contains(string(body), `<span class="file-badge">stdin</span>`) {
+		t.Errorf("Expected the index page to mark the stdin document, got:\n%s", body)
+	}
+
+	output, err = lum("--list")
+	if err != nil || !strings.Contains(output, id) {
+		t.Errorf("Expected %s to be listed, got:\n%s", id, output)
+	}
+
+	if output, err := lum("--remove", id); err != nil {
+		t.Fatalf("Failed to remove stdin document: %v\nOutput: %s", err, output)
+	}
+	output, err = lum("--list")
+	if err != nil || strings.Contains(output, id) {
+		t.Errorf("Expected %s to be removed, got:\n%s", id, output)
+	}
+}
+
+func TestIntegrationStaleSocket(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	binaryPath := getTestBinary(t)
+	runtimeDir := t.TempDir()
+
+	// lum runs the binary with the given arguments in the shared runtime directory
+	lum := binaryRunner(t, binaryPath, runtimeDir)
+
+	if output, err := lum("--daemon", "--port", "16511"); err != nil {
+		t.Fatalf("Failed to start daemon: %v\nOutput: %s", err, output)
+	}
+	t.Cleanup(func() {
+		_, _ = lum("--stop", "--force")
+	})
+
+	socketPath := filepath.Join(runtimeDir, "lum", "control.sock")
+	pid, err := readPIDFile(socketPath)
+	if err != nil {
+		t.Fatalf("Expected daemon to write a valid PID file: %v", err)
+	}
+
+	// A killed daemon leaves its socket behind
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+		t.Fatalf("Failed to kill daemon: %v", err)
+	}
+	if err := waitForExit(pid, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(socketPath); err != nil {
+		t.Fatalf("Expected killed daemon to leave its socket behind: %v", err)
+	}
+
+	output, err := lum("--stop")
+	if err == nil || !strings.Contains(output, "no daemon running") {
+		t.Errorf("Expected 'no daemon running', got %v:\n%s", err, output)
+	}
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Error("Expected stale socket to be removed")
+	}
+
+	// The next daemon starts without complaining about the old one
+	if output, err := lum("--daemon", "--port", "16511"); err != nil {
+		t.Fatalf("Failed to start daemon after stale socket: %v\nOutput: %s", err, output)
+	}
+	if output, err := lum("--stop"); err != nil {
+		t.Fatalf("Failed to stop daemon: %v\nOutput: %s", err, output)
+	}
+}
+
+// TestIntegrationIncompatibleServer tests that a server which cannot be used is reported and left running,
+// rather than replaced by a one-off server.
+func TestIntegrationIncompatibleServer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	binaryPath := getTestBinary(t)
+	runtimeDir := t.TempDir()
+
+	testFile := filepath.Join(t.TempDir(), "test.md")
+	if err := os.WriteFile(testFile, []byte("# Test"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fake a server that only speaks a future protocol version
+	if err := os.Mkdir(filepath.Join(runtimeDir, "lum"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	socketPath := filepath.Join(runtimeDir, "lum", "control.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = bufio.NewReader(conn).ReadString('\n')
+			_, _ = fmt.Fprintf(conn, `{"id":1,"error":{"code":"protocol_mismatch","message":"too old"}}`+"\n")
+			_ = conn.Close()
+		}
+	}()
+
+	before, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := binaryOutput(t, binaryPath, binaryRun{runtimeDir: runtimeDir}, "--port", "auto", testFile)
+	if err == nil || !strings.Contains(output, "incompatible server") {
+		t.Errorf("Expected the incompatible server to be reported, got %v:\n%s", err, output)
+	}
+
+	after, err := os.Stat(socketPath)
+	if err != nil || !os.SameFile(before, after) {
+		t.Errorf("Expected the server to keep its socket: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(runtimeDir, "lum", "lum.pid")); !os.IsNotExist(err) {
+		t.Error("Expected no PID file to be written")
+	}
+}
+
+// TestIntegrationDaemonStartupFailure tests that the parent process reports a daemon that fails to start.
+func TestIntegrationDaemonStartupFailure(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	binaryPath := getTestBinary(t)
+
+	// Occupy the port so that the daemonized child cannot bind it
+	listener, err := net.Listen("tcp", "127.0.0.1:16503")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	cmd := runBinary(t, binaryPath, "--daemon", "--port", "16503")
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("Expected binary to exit with error when the port is taken\nOutput: %s", output)
+	}
+
+	if !strings.Contains(string(output), "Failed to start daemon:") ||
+		!strings.Contains(string(output), "address already in use") {
+		t.Errorf("Expected the daemon's startup error, got:\n%s", output)
+	}
+}
+
 // TestIntegrationInvalidFile tests error handling with a compiled binary.
 func TestIntegrationInvalidFile(t *testing.T) {
 	if testing.Short() {
@@ -363,7 +1511,7 @@ func TestIntegrationInvalidFile(t *testing.T) {
 		t.Error("Expected binary to exit with error for nonexistent file")
 	}
 
-	expectedOutput := "File does not exist: /nonexistent/file.md\n"
+	expectedOutput := "Failed to add /nonexistent/file.md: file does not exist\n"
 	actualOutput := string(output)
 	if !strings.HasPrefix(actualOutput, expectedOutput) {
 		t.Errorf("Expected error message to start with:\n%q\nGot:\n%q", expectedOutput, actualOutput)
@@ -385,21 +1533,52 @@ func TestIntegrationHelp(t *testing.T) {
 		t.Fatalf("Failed to run help: %v", err)
 	}
 
-	expectedOutput := `Usage: lum [OPTIONS] [FILE]
+	expectedOutput := `Usage: lum [OPTIONS] [FILE...]
 
 Render Markdown files in a web browser with live reload.
 
 Options:
-  -p, --port PORT     Port to run the server on (default: 6333)
-  -d, --daemon        Run as daemon (allows serving multiple files)
-  -s, --stop          Stop the running daemon
-  -h, --help          Show this help message
+  -p, --port PORT              Port to run the server on, 0 or auto for any free port (default: 6333)
+      --host ADDR              Address to bind, 0.0.0.0 or :: for all interfaces (default: 127.0.0.1)
+      --token TOKEN            Require TOKEN for HTTP requests, required off loopback (or set LUM_TOKEN)
+      --auth USER:PASSWORD     Require basic-auth credentials for HTTP requests (or set LUM_AUTH)
+  -d, --daemon                 Run as daemon (allows serving multiple files)
+      --foreground             With --daemon, stay in the foreground and log to stderr
+      --no-restore             Start the daemon without restoring previously served files
+      --idle-timeout DURATION  Exit the daemon after DURATION (e.g. 30m) without viewers or commands
+      --instance NAME          Select a named daemon instance with its own socket, log and state
+      --list-instances         List running daemon instances
+  -s, --stop                   Stop the running daemon
+      --force                  With --stop, kill the daemon if it does not exit in time
+      --restart                Restart the running daemon on the installed binary, keeping its files
+      --remove FILE            Stop serving a file or directory in the running daemon
+      --include GLOB           Track files matching GLOB in directories, repeatable (default: *.md, *.markdown)
+      --exclude GLOB           Skip files and directories matching GLOB in directories, repeatable
+  -l, --list                   List files served by the running daemon
+      --status                 Show the running daemon's status
+      --json                   Print --list, --status and --list-instances output as JSON
+  -v, --verbose                Log to stderr in one-off mode, including debug records
+      --log-level LEVEL        Minimum level to log: debug, info, warn or error (default: info)
+      --log-format FORMAT      Log format: text or json (default: text)
+      --access-log             Log HTTP requests and SSE connections
+      --log-max-size SIZE      Rotate the daemon log at SIZE, e.g. 512K or 10M (default: 10M)
+      --log-keep N             Number of rotated daemon logs to keep (default: 3)
+      --logs                   Print the running daemon's log
+  -f, --follow                 With --logs, keep printing new log records
+      --version                Print the version of lum and of the running daemon
+  -h, --help                   Show this help message
 
 Examples:
   lum file.md              Serve file in one-off mode
   lum --daemon             Start daemon with no files
   lum --daemon file.md     Start daemon with initial file
   lum file.md              Add file to existing daemon (if running)
+  lum 'docs/*.md' a.md     Serve or add several files, expanding quoted patterns
+  lum docs/                Serve every Markdown file below docs/, including new ones
+  cat notes.md | lum -     Serve Markdown read from stdin
+  lum --remove file.md     Remove file from the daemon
+  lum --list               Show files served by the daemon
+  lum --status             Show daemon PID, address and version
   lum --stop               Stop the daemon
 `
 	actualOutput := string(output)
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultLogMaxSize is the size at which the daemon log is rotated unless --log-max-size is given
+	defaultLogMaxSize = 10 << 20
+	// defaultLogKeep is the number of rotated logs kept unless --log-keep is given
+	defaultLogKeep = 3
+	// followInterval is how often 'lum --logs --follow' checks the log for new records
+	followInterval = 250 * time.Millisecond
+)
+
+var (
+	// logMaxSize is the size in bytes at which the daemon log is rotated, set with --log-max-size
+	logMaxSize int64 = defaultLogMaxSize
+
+	// logKeep is the number of rotated logs (lum.log.1, lum.log.2, ...) kept next to the log, set with --log-keep
+	logKeep = defaultLogKeep
+)
+
+// parseSize parses a size in bytes with an optional K, M or G suffix (powers of 1024)
+func parseSize(value string) (int64, error) {
+	multiplier := int64(1)
+	number := strings.ToUpper(value)
+	for suffix, factor := range map[string]int64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30} {
+		if trimmed, found := strings.CutSuffix(number, suffix); found {
+			number = trimmed
+			multiplier = factor
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid size: %s (use bytes or a number with K, M or G)", value)
+	}
+
+	return size * multiplier, nil
+}
+
+// rotatingLogFile is a log file that is rotated once it reaches maxSize. The current log is renamed to
+// path.1, path.1 to path.2 and so on, and logs beyond keep are removed.
+type rotatingLogFile struct {
+	path    string
+	maxSize int64
+	keep    int
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+// openRotatingLogFile opens the log file at path for appending
+func openRotatingLogFile(path string, maxSize int64, keep int) (*rotatingLogFile, error) {
+	f := &rotatingLogFile{path: path, maxSize: maxSize, keep: keep}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// open opens the log file and picks up its current size
+func (f *rotatingLogFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write appends a record, rotating the log first if the record would take it past its maximum size
+func (f *rotatingLogFile) Write(data []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.size > 0 && f.size+int64(len(data)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			// Keep logging to the current file rather than losing records
+			fmt.Fprintf(os.Stderr, "Failed to rotate log: %v\n", err)
+		}
+	}
+
+	n, err := f.file.Write(data)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the rotated logs by one and starts a new log file. The current file stays open until its
+// successor has been opened, so that a failed rotation never leaves the log without a file to write to.
+func (f *rotatingLogFile) rotate() error {
+	shiftErr := f.shift()
+
+	// Reopening continues the current log if it could not be moved aside, or recreates it if it was deleted
+	current := f.file
+	if err := f.open(); err != nil {
+		return errors.Join(shiftErr, err)
+	}
+	_ = current.Close()
+
+	return shiftErr
+}
+
+// shift moves the log file and the rotated logs up by one, dropping the oldest
+func (f *rotatingLogFile) shift() error {
+	if f.keep == 0 {
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	if err := os.Remove(rotatedLogPath(f.path, f.keep)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for i := f.keep - 1; i >= 1; i-- {
+		err := os.Rename(rotatedLogPath(f.path, i), rotatedLogPath(f.path, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return os.Rename(f.path, rotatedLogPath(f.path, 1))
+}
+
+// Close closes the log file
+func (f *rotatingLogFile) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.file.Close()
+}
+
+// rotatedLogPath returns the path of the n-th rotated log
+func rotatedLogPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// findLogPath returns the log file of the selected instance. A running daemon is asked for its log, so that
+// the log of the active daemon is found even if it was started with another runtime directory.
+func findLogPath() (string, error) {
+	if status, err := getExistingServerStatus(); err == nil {
+		if status.Mode == modeOneOff {
+			return "", fmt.Errorf("the running server is a one-off server, which does not write a log")
+		}
+		if status.LogPath == "" {
+			return "", fmt.Errorf("the running daemon runs in the foreground and logs to stderr")
+		}
+		return status.LogPath, nil
+	}
+
+	logPath, err := getLogPath()
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(logPath); err != nil {
+		return "", fmt.Errorf("no log found: %w", err)
+	}
+
+	return logPath, nil
+}
+
+// printLogs writes the log at path to w. With follow, it keeps writing new records as they are logged,
+// continuing with the new file when the log is rotated, until stop is closed.
+func printLogs(w io.Writer, path string, follow bool, stop <-chan struct{}) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open log: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	if _, err := io.Copy(w, file); err != nil {
+		return fmt.Errorf("failed to read log: %w", err)
+	}
+	if !follow {
+		return nil
+	}
+
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+
+		if _, err := io.Copy(w, file); err != nil {
+			return fmt.Errorf("failed to read log: %w", err)
+		}
+
+		// After a rotation the path refers to a new file, which is read from its start
+		current, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		opened, err := file.Stat()
+		if err != nil || os.SameFile(current, opened) {
+			continue
+		}
+
+		rotated, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		_ = file.Close()
+		file = rotated
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"512": 512,
+		"10K": 10 << 10,
+		"5m":  5 << 20,
+		"1G":  1 << 30,
+	}
+	for value, expected := range tests {
+		size, err := parseSize(value)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", value, err)
+			continue
+		}
+		if size != expected {
+			t.Errorf("parseSize(%q) = %d, expected %d", value, size, expected)
+		}
+	}
+
+	for _, value := range []string{"", "0", "-5M", "lots", "10MB"} {
+		if _, err := parseSize(value); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
+
+func TestRotatingLogFile(t *testing.T) {
+	t.Run("RotatesAndKeepsLogs", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "lum.log")
+		logFile, err := openRotatingLogFile(path, 100, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = logFile.Close() }()
+
+		line := strings.Repeat("x", 39) + "\n"
+		for range 10 {
+			if _, err := logFile.Write([]byte(line)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, kept := range []string{path, path + ".1", path + ".2"} {
+			info, err := os.Stat(kept)
+			if err != nil {
+				t.Fatalf("Expected %s to exist: %v", kept, err)
+			}
+			if info.Size() > 100 {
+				t.Errorf("Expected %s to stay below the maximum size, got %d bytes", kept, info.Size())
+			}
+		}
+		if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+			t.Error("Expected logs beyond the kept number to be removed")
+		}
+	})
+
+	t.Run("FailedRotationKeepsLogging", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "lum.log")
+		logFile, err := openRotatingLogFile(path, 100, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = logFile.Close() }()
+
+		if _, err := logFile.Write([]byte(strings.Repeat("x", 90) + "\n")); err != nil {
+			t.Fatal(err)
+		}
+
+		// Moving the deleted log aside fails, the next record must still be written
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+		if err := logFile.rotate(); err == nil {
+			t.Error("Expected the rotation to fail")
+		}
+		for _, record := range []string{"after failure\n", "still logging\n"} {
+			if _, err := logFile.Write([]byte(record)); err != nil {
+				t.Fatalf("Expected logging to continue after a failed rotation, got %v", err)
+			}
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Expected the log to be recreated: %v", err)
+		}
+		if string(data) != "after failure\nstill logging\n" {
+			t.Errorf("Expected the records written after the failed rotation, got %q", data)
+		}
+	})
+
+	t.Run("ContinuesExistingLog", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "lum.log")
+		if err := os.WriteFile(path, []byte(strings.Repeat("x", 90)), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		logFile, err := openRotatingLogFile(path, 100, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = logFile.Close() }()
+
+		if _, err := logFile.Write([]byte("new record\n")); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "new record\n" {
+			t.Errorf("Expected the existing log to be rotated, got %q", data)
+		}
+	})
+
+	t.Run("KeepNone", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "lum.log")
+		logFile, err := openRotatingLogFile(path, 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = logFile.Close() }()
+
+		for _, record := range []string{"first...\n", "second..\n"} {
+			if _, err := logFile.Write([]byte(record)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "second..\n" {
+			t.Errorf("Expected only the latest record, got %q", data)
+		}
+		if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+			t.Error("Expected no rotated log to be kept")
+		}
+	})
+}
+
+// syncBuffer is a bytes.Buffer that can be written and read from different goroutines
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(data []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(data)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+func TestPrintLogs(t *testing.T) {
+	t.Run("Print", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "lum.log")
+		if err := os.WriteFile(path, []byte("first\nsecond\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := printLogs(&buf, path, false, nil); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != "first\nsecond\n" {
+			t.Errorf("Unexpected output: %q", buf.String())
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := printLogs(&buf, filepath.Join(t.TempDir(), "lum.log"), false, nil); err == nil {
+			t.Error("Expected error for missing log")
+		}
+	})
+
+	t.Run("FollowAcrossRotation", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "lum.log")
+		logFile, err := openRotatingLogFile(path, 20, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = logFile.Close() }()
+		if _, err := logFile.Write([]byte("first record\n")); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf syncBuffer
+		stop := make(chan struct{})
+		done := make(chan error, 1)
+		go func() {
+			done <- printLogs(&buf, path, true, stop)
+		}()
+
+		waitFor := func(expected string) {
+			deadline := time.Now().Add(2 * time.Second)
+			for !strings.Contains(buf.String(), expected) {
+				if time.Now().After(deadline) {
+					t.Fatalf("Expected %q to be followed, got %q", expected, buf.String())
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+		}
+
+		waitFor("first record\n")
+
+		// Rotates the log, the record ends up in the new file
+		if _, err := logFile.Write([]byte("second record\n")); err != nil {
+			t.Fatal(err)
+		}
+		waitFor("second record\n")
+
+		close(stop)
+		if err := <-done; err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Log formats accepted by --log-format
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+var (
+	// logLevel is the minimum level of logged records, set with --log-level
+	logLevel = new(slog.LevelVar)
+
+	// logFormat is the format of logged records, set with --log-format
+	logFormat = logFormatText
+
+	// accessLog enables logging every HTTP request and SSE connection, set with --access-log
+	accessLog bool
+)
+
+// parseLogLevel parses a --log-level value
+func parseLogLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return level, fmt.Errorf("invalid log level: %s (use debug, info, warn or error)", value)
+	}
+	return level, nil
+}
+
+// parseLogFormat parses a --log-format value
+func parseLogFormat(value string) (string, error) {
+	switch value {
+	case logFormatText, logFormatJSON:
+		return value, nil
+	default:
+		return "", fmt.Errorf("invalid log format: %s (use text or json)", value)
+	}
+}
+
+// setupLogger sends all log output, including that of the log package, to w in the configured format
+func setupLogger(w io.Writer) {
+	handlerOptions := &slog.HandlerOptions{Level: logLevel}
+
+	var handler slog.Handler
+	if logFormat == logFormatJSON {
+		handler = slog.NewJSONHandler(w, handlerOptions)
+	} else {
+		handler = slog.NewTextHandler(w, handlerOptions)
+	}
+
+	slog.SetDefault(slog.New(handler))
+}
+
+// accessLogResponseWriter records the status code and size of a response for the access log
+type accessLogResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// WriteHeader records the status code
+func (w *accessLogResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the size of the response
+func (w *accessLogResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.bytes += n
+	return n, err
+}
+
+// Flush lets SSE handlers flush through the wrapper
+func (w *accessLogResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the wrapped writer
+func (w *accessLogResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withAccessLog wraps handler to log every request if the access log is enabled. SSE connections are logged
+// when they are opened and when they are closed.
+func withAccessLog(handler http.Handler) http.Handler {
+	if !accessLog {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &accessLogResponseWriter{ResponseWriter: w}
+		attrs := []any{"method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr}
+		if file := r.URL.Query().Get("file"); file != "" {
+			attrs = append(attrs, "file", file)
+		}
+
+		isSSE := strings.HasPrefix(r.URL.Path, "/events")
+		if isSSE {
+			slog.Info("SSE client connected", attrs...)
+		}
+
+		handler.ServeHTTP(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		attrs = append(attrs, "status", recorder.status, "bytes", recorder.bytes, "duration", time.Since(start))
+		if isSSE {
+			slog.Info("SSE client disconnected", attrs...)
+		} else {
+			slog.Info("HTTP request", attrs...)
+		}
+	})
+}
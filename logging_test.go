@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureLogs sends log records to a buffer in the given format for the duration of the test
+func captureLogs(t *testing.T, format string) *bytes.Buffer {
+	oldLogger := slog.Default()
+	oldFormat := logFormat
+	oldLevel := logLevel.Level()
+	t.Cleanup(func() {
+		slog.SetDefault(oldLogger)
+		logFormat = oldFormat
+		logLevel.Set(oldLevel)
+	})
+
+	var buf bytes.Buffer
+	logFormat = format
+	setupLogger(&buf)
+	return &buf
+}
+
+func TestParseLogOptions(t *testing.T) {
+	for _, value := range []string{"debug", "info", "warn", "error", "DEBUG"} {
+		if _, err := parseLogLevel(value); err != nil {
+			t.Errorf("Expected log level %q to be accepted, got %v", value, err)
+		}
+	}
+	if _, err := parseLogLevel("loud"); err == nil {
+		t.Error("Expected error for invalid log level")
+	}
+
+	for _, value := range []string{logFormatText, logFormatJSON} {
+		if _, err := parseLogFormat(value); err != nil {
+			t.Errorf("Expected log format %q to be accepted, got %v", value, err)
+		}
+	}
+	if _, err := parseLogFormat("xml"); err == nil {
+		t.Error("Expected error for invalid log format")
+	}
+}
+
+func TestSetupLogger(t *testing.T) {
+	t.Run("JSON", func(t *testing.T) {
+		buf := captureLogs(t, logFormatJSON)
+
+		slog.Info("Added file via control socket", "path", "/tmp/test.md")
+
+		var record map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+			t.Fatalf("Expected a JSON record, got %q: %v", buf.String(), err)
+		}
+		if record["msg"] != "Added file via control socket" || record["path"] != "/tmp/test.md" {
+			t.Errorf("Unexpected record: %v", record)
+		}
+	})
+
+	t.Run("Level", func(t *testing.T) {
+		buf := captureLogs(t, logFormatText)
+		logLevel.Set(slog.LevelWarn)
+
+		slog.Info("hidden")
+		slog.Warn("shown")
+
+		if strings.Contains(buf.String(), "hidden") || !strings.Contains(buf.String(), "shown") {
+			t.Errorf("Expected only records at or above the level, got %q", buf.String())
+		}
+	})
+}
+
+func TestWithAccessLog(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("Expected the response writer to support flushing")
+		}
+		http.NotFound(w, r)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		buf := captureLogs(t, logFormatText)
+
+		w := httptest.NewRecorder()
+		withAccessLog(handler).ServeHTTP(w, httptest.NewRequest("GET", "/missing.png", nil))
+
+		if buf.Len() != 0 {
+			t.Errorf("Expected no access log, got %q", buf.String())
+		}
+	})
+
+	t.Run("Enabled", func(t *testing.T) {
+		buf := captureLogs(t, logFormatText)
+		accessLog = true
+		defer func() { accessLog = false }()
+
+		w := httptest.NewRecorder()
+		withAccessLog(handler).ServeHTTP(w, httptest.NewRequest("GET", "/missing.png?file=/tmp/test.md", nil))
+
+		output := buf.String()
+		expectedAttrs := []string{`msg="HTTP request"`, "path=/missing.png", "file=/tmp/test.md", "status=404"}
+		for _, expected := range expectedAttrs {
+			if !strings.Contains(output, expected) {
+				t.Errorf("Expected %q in access log, got %q", expected, output)
+			}
+		}
+	})
+
+	t.Run("SSE", func(t *testing.T) {
+		buf := captureLogs(t, logFormatText)
+		accessLog = true
+		defer func() { accessLog = false }()
+
+		w := httptest.NewRecorder()
+		withAccessLog(handler).ServeHTTP(w, httptest.NewRequest("GET", "/events?file=/tmp/test.md", nil))
+
+		output := buf.String()
+		if !strings.Contains(output, `msg="SSE client connected"`) ||
+			!strings.Contains(output, `msg="SSE client disconnected"`) {
+			t.Errorf("Expected SSE connect and disconnect records, got %q", output)
+		}
+	})
+}
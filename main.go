@@ -1,52 +1,130 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
+	"text/tabwriter"
+	"time"
 )
 
+const (
+	// shutdownTimeout bounds how long a graceful shutdown waits for open connections to close
+	shutdownTimeout = 5 * time.Second
+	// stopTimeout bounds how long --stop waits for the daemon process to exit
+	stopTimeout = 10 * time.Second
+)
+
+// shutdownChan is signalled to request a graceful shutdown of the running server
+var shutdownChan = make(chan struct{}, 1)
+
 type options struct {
-	port   int
-	daemon bool
-	stop   bool
-	help   bool
+	port          int
+	portSet       bool
+	host          string
+	credentials   accessCredentials
+	daemon        bool
+	foreground    bool
+	noRestore     bool
+	idleTimeout   time.Duration
+	instance      string
+	listInstances bool
+	stop          bool
+	force         bool
+	restart       bool
+	remove        string
+	list          bool
+	status        bool
+	json          bool
+	version       bool
+	help          bool
+	verbose       bool
+	logLevel      string
+	logFormat     string
+	accessLog     bool
+	logMaxSize    int64
+	logKeep       int
+	logs          bool
+	follow        bool
+	filter        directoryFilter
 }
 
 func printUsage() {
-	fmt.Fprintf(os.Stderr, `Usage: lum [OPTIONS] [FILE]
+	fmt.Fprintf(os.Stderr, `Usage: lum [OPTIONS] [FILE...]
 
 Render Markdown files in a web browser with live reload.
 
 Options:
-  -p, --port PORT     Port to run the server on (default: 6333)
-  -d, --daemon        Run as daemon (allows serving multiple files)
-  -s, --stop          Stop the running daemon
-  -h, --help          Show this help message
+  -p, --port PORT              Port to run the server on, 0 or auto for any free port (default: 6333)
+      --host ADDR              Address to bind, 0.0.0.0 or :: for all interfaces (default: 127.0.0.1)
+      --token TOKEN            Require TOKEN for HTTP requests, required off loopback (or set LUM_TOKEN)
+      --auth USER:PASSWORD     Require basic-auth credentials for HTTP requests (or set LUM_AUTH)
+  -d, --daemon                 Run as daemon (allows serving multiple files)
+      --foreground             With --daemon, stay in the foreground and log to stderr
+      --no-restore             Start the daemon without restoring previously served files
+      --idle-timeout DURATION  Exit the daemon after DURATION (e.g. 30m) without viewers or commands
+      --instance NAME          Select a named daemon instance with its own socket, log and state
+      --list-instances         List running daemon instances
+  -s, --stop                   Stop the running daemon
+      --force                  With --stop, kill the daemon if it does not exit in time
+      --restart                Restart the running daemon on the installed binary, keeping its files
+      --remove FILE            Stop serving a file or directory in the running daemon
+      --include GLOB           Track files matching GLOB in directories, repeatable (default: *.md, *.markdown)
+      --exclude GLOB           Skip files and directories matching GLOB in directories, repeatable
+  -l, --list                   List files served by the running daemon
+      --status                 Show the running daemon's status
+      --json                   Print --list, --status and --list-instances output as JSON
+  -v, --verbose                Log to stderr in one-off mode, including debug records
+      --log-level LEVEL        Minimum level to log: debug, info, warn or error (default: info)
+      --log-format FORMAT      Log format: text or json (default: text)
+      --access-log             Log HTTP requests and SSE connections
+      --log-max-size SIZE      Rotate the daemon log at SIZE, e.g. 512K or 10M (default: 10M)
+      --log-keep N             Number of rotated daemon logs to keep (default: 3)
+      --logs                   Print the running daemon's log
+  -f, --follow                 With --logs, keep printing new log records
+      --version                Print the version of lum and of the running daemon
+  -h, --help                   Show this help message
 
 Examples:
   lum file.md              Serve file in one-off mode
   lum --daemon             Start daemon with no files
   lum --daemon file.md     Start daemon with initial file
   lum file.md              Add file to existing daemon (if running)
+  lum 'docs/*.md' a.md     Serve or add several files, expanding quoted patterns
+  lum docs/                Serve every Markdown file below docs/, including new ones
+  cat notes.md | lum -     Serve Markdown read from stdin
+  lum --remove file.md     Remove file from the daemon
+  lum --list               Show files served by the daemon
+  lum --status             Show daemon PID, address and version
   lum --stop               Stop the daemon
 `)
 }
 
 func parseArgs(args []string) (*options, []string, error) {
 	opts := &options{
-		port: 6333,
+		port:       6333,
+		host:       defaultHost,
+		instance:   defaultInstance,
+		logFormat:  logFormatText,
+		logMaxSize: defaultLogMaxSize,
+		logKeep:    defaultLogKeep,
 	}
 	var positional []string
+	// Credentials can be given in the environment, to keep them out of the process list
+	token, auth := os.Getenv(tokenEnv), os.Getenv(authEnv)
 
 	for i := 0; i < len(args); i++ {
 		arg := args[i]
@@ -54,31 +132,181 @@ func parseArgs(args []string) (*options, []string, error) {
 		switch arg {
 		case "-h", "--help":
 			opts.help = true
+		case "--version":
+			opts.version = true
+		case "-v", "--verbose":
+			opts.verbose = true
+		case "--log-level":
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+			i++
+			if _, err := parseLogLevel(args[i]); err != nil {
+				return nil, nil, err
+			}
+			opts.logLevel = args[i]
+		case "--log-format":
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+			i++
+			format, err := parseLogFormat(args[i])
+			if err != nil {
+				return nil, nil, err
+			}
+			opts.logFormat = format
+		case "--access-log":
+			opts.accessLog = true
+		case "--log-max-size":
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+			i++
+			size, err := parseSize(args[i])
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid log size: %w", err)
+			}
+			opts.logMaxSize = size
+		case "--log-keep":
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+			i++
+			keep, err := strconv.Atoi(args[i])
+			if err != nil || keep < 0 {
+				return nil, nil, fmt.Errorf("invalid number of kept logs: %s", args[i])
+			}
+			opts.logKeep = keep
+		case "--logs":
+			opts.logs = true
+		case "-f", "--follow":
+			opts.follow = true
 		case "-d", "--daemon":
 			opts.daemon = true
+		case "--foreground":
+			opts.foreground = true
+		case "--no-restore":
+			opts.noRestore = true
+		case "--idle-timeout":
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+			i++
+			timeout, err := time.ParseDuration(args[i])
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid idle timeout value: %s", args[i])
+			}
+			if timeout <= 0 {
+				return nil, nil, fmt.Errorf("idle timeout must be positive: %s", args[i])
+			}
+			opts.idleTimeout = timeout
+		case "--instance":
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+			i++
+			if err := validateInstanceName(args[i]); err != nil {
+				return nil, nil, err
+			}
+			opts.instance = args[i]
+		case "--list-instances":
+			opts.listInstances = true
 		case "-s", "--stop":
 			opts.stop = true
+		case "--force":
+			opts.force = true
+		case "--restart":
+			opts.restart = true
+		case "--remove":
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+			i++
+			opts.remove = args[i]
+		case "--include", "--exclude":
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+			i++
+			if arg == "--include" {
+				opts.filter.Include = append(opts.filter.Include, args[i])
+			} else {
+				opts.filter.Exclude = append(opts.filter.Exclude, args[i])
+			}
+		case "-l", "--list":
+			opts.list = true
+		case "--status":
+			opts.status = true
+		case "--json":
+			opts.json = true
 		case "-p", "--port":
 			if i+1 >= len(args) {
 				return nil, nil, fmt.Errorf("flag needs an argument: %s", arg)
 			}
 			i++
-			port, err := strconv.Atoi(args[i])
+			// Port 0 (or "auto") binds any free port
+			value := args[i]
+			if value == "auto" {
+				value = "0"
+			}
+			port, err := strconv.Atoi(value)
 			if err != nil {
 				return nil, nil, fmt.Errorf("invalid port value: %s", args[i])
 			}
-			if port < 1 || port > 65535 {
-				return nil, nil, fmt.Errorf("port must be between 1 and 65535: %d", port)
+			if port < 0 || port > 65535 {
+				return nil, nil, fmt.Errorf("port must be between 0 (any free port) and 65535: %d", port)
 			}
 			opts.port = port
+			opts.portSet = true
+		case "--host":
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+			i++
+			opts.host = normalizeHost(args[i])
+			if opts.host == "" {
+				return nil, nil, fmt.Errorf("invalid host: %s", args[i])
+			}
+		case "--token", "--auth":
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+			i++
+			if arg == "--token" {
+				token = args[i]
+			} else {
+				auth = args[i]
+			}
 		default:
-			if strings.HasPrefix(arg, "-") {
+			// A lone "-" reads a document from stdin
+			if strings.HasPrefix(arg, "-") && arg != stdinArg {
 				return nil, nil, fmt.Errorf("unknown flag: %s", arg)
 			}
 			positional = append(positional, arg)
 		}
 	}
 
+	if opts.force && !opts.stop {
+		return nil, nil, fmt.Errorf("--force can only be used with --stop")
+	}
+	if opts.foreground && !opts.daemon {
+		return nil, nil, fmt.Errorf("--foreground can only be used with --daemon")
+	}
+	if opts.follow && !opts.logs {
+		return nil, nil, fmt.Errorf("--follow can only be used with --logs")
+	}
+	if err := opts.filter.validate(); err != nil {
+		return nil, nil, err
+	}
+	creds, err := newAccessCredentials(token, auth)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts.credentials = creds
+	if err := checkExposure(bindAddr(opts.host, opts.port), opts.credentials); err != nil {
+		return nil, nil, err
+	}
+
 	return opts, positional, nil
 }
 
@@ -99,109 +327,420 @@ func run() int {
 		return 0
 	}
 
-	port := opts.port
+	// Every path derived from the runtime directory belongs to the selected instance
+	instanceName = opts.instance
+
+	// Every mode logs with the same settings, only where the records go differs.
+	// Verbose one-off servers include debug records unless a level is given.
+	if opts.logLevel != "" {
+		level, _ := parseLogLevel(opts.logLevel)
+		logLevel.Set(level)
+	} else if opts.verbose {
+		logLevel.Set(slog.LevelDebug)
+	}
+	logFormat = opts.logFormat
+	accessLog = opts.accessLog
+	logMaxSize = opts.logMaxSize
+	logKeep = opts.logKeep
+
+	// Handle --version
+	if opts.version {
+		printVersion(os.Stdout)
+		return 0
+	}
+
 	daemon := opts.daemon
 	stop := opts.stop
 
+	// Handle --list-instances
+	if opts.listInstances {
+		instances, err := listRunningInstances()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list instances: %v\n", err)
+			return 1
+		}
+		if err := printInstanceList(os.Stdout, instances, opts.json); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to print instance list: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
 	// Handle --stop
 	if stop {
-		if err := stopDaemon(); err != nil {
+		if err := stopDaemon(opts.force); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to stop daemon: %v\n", err)
 			return 1
 		}
 		return 0
 	}
 
+	// Handle --restart
+	if opts.restart {
+		// Running a different version is the reason to restart, no need to warn about it
+		versionWarnings = nil
+		status, err := restartExistingDaemon(opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to restart daemon: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Restarted daemon (PID %d, version %s)\n", status.PID, status.Version)
+		return 0
+	}
+
+	// Handle --remove
+	if opts.remove != "" {
+		absPath, err := filepath.Abs(opts.remove)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get absolute path: %v\n", err)
+			return 1
+		}
+		// Documents read from stdin are removed by their ID, unless a file of that name exists
+		if _, err := os.Stat(absPath); err != nil && isStdinID(opts.remove) {
+			absPath = opts.remove
+		}
+		if err := removeFromExistingServer(absPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to remove file: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	// Handle --list
+	if opts.list {
+		list, err := listExistingServerFiles()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list files: %v\n", err)
+			return 1
+		}
+		if err := printFileList(os.Stdout, list, opts.json); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to print file list: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	// Handle --status
+	if opts.status {
+		status, err := getExistingServerStatus()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "No daemon reachable: %v\n", err)
+			return 1
+		}
+		if err := printStatus(os.Stdout, status, opts.json); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to print status: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	// Handle --logs
+	if opts.logs {
+		logPath, err := findLogPath()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to find daemon log: %v\n", err)
+			return 1
+		}
+		// Following ends with Ctrl+C
+		if err := printLogs(os.Stdout, logPath, opts.follow, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to print daemon log: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
 	// Handle --daemon mode
 	if daemon {
 		// Check if we're the daemonized child process
 		isDaemonized := os.Getenv("LUM_DAEMONIZED") == "1"
 
 		if !isDaemonized {
+			// Sockets passed by systemd belong to this process: there is no other daemon listening on them, and
+			// the daemon has to stay in the process systemd started
+			activated := socketActivated()
+			if activated {
+				opts.foreground = true
+			}
+
 			// Parent process - validate and daemonize
-			if daemonExists() {
-				fmt.Fprintf(os.Stderr, "Daemon already running\n")
+			docs, failures, err := readDocuments(args, opts.filter, os.Stdin)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
 				return 1
 			}
-
-			// Daemon mode allows 0 or 1 file arguments
-			if len(args) > 1 {
-				fmt.Fprintf(os.Stderr, "Usage: lum --daemon [<path-to-markdown-file>]\n")
+			printAddResults(os.Stdout, os.Stderr, failures)
+			if len(args) > 0 && docs.empty() {
 				return 1
 			}
 
-			var initialFile string
-			if len(args) == 1 {
-				absPath, err := filepath.Abs(args[0])
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Failed to get absolute path: %v\n", err)
-					return 1
+			if !activated && daemonExists() {
+				// A running one-off server can be turned into the daemon instead, unless the caller wants to
+				// supervise the daemon process itself
+				status, err := getExistingServerStatus()
+				if err == nil && status.Mode == modeOneOff && !opts.foreground {
+					warnIgnoredPort(opts)
+					url, err := promoteExistingServer()
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Failed to promote one-off server to daemon: %v\n", err)
+						return 1
+					}
+					return addToStartedDaemon(url, docs, len(failures))
+				}
+
+				if instanceName != defaultInstance {
+					fmt.Fprintf(os.Stderr, "Daemon instance %s already running\n", instanceName)
+				} else {
+					fmt.Fprintf(os.Stderr, "Daemon already running\n")
 				}
-				if _, err := os.Stat(absPath); os.IsNotExist(err) {
-					fmt.Fprintf(os.Stderr, "File does not exist: %s\n", absPath)
+				return 1
+			}
+
+			// Serve from this process, for supervisors such as systemd that manage the daemon themselves
+			if opts.foreground {
+				if err := startDaemon(opts, docs); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to start daemon: %v\n", err)
 					return 1
 				}
-				initialFile = absPath
+				return 0
 			}
 
-			// Daemonize and exit
-			if err := daemonize(port, initialFile); err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to daemonize: %v\n", err)
+			// Daemonize and exit once the child is serving and has been given the files
+			url, err := daemonize(opts, nil)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to start daemon: %v\n", err)
+				if logPath, err := getLogPath(); err == nil {
+					fmt.Fprintf(os.Stderr, "See %s for details\n", logPath)
+				}
 				return 1
 			}
 			// Parent process exits here
-			return 0
-		}
-
-		// We are the daemonized child - parse args again to get initialFile
-		var initialFile string
-		if len(args) == 1 {
-			initialFile = args[0] // Already validated and converted to absolute path by parent
+			status := addToStartedDaemon(url, docs, len(failures))
+			printNetworkURLs(os.Stdout, opts.host, url)
+			return status
 		}
 
-		// Start the daemon server
-		if err := startDaemon(port, initialFile); err != nil {
+		// We are the daemonized child, files given on the command line have already been expanded
+		openReadyPipe()
+		if err := startDaemon(opts, documents{paths: args, filter: opts.filter}); err != nil {
+			reportStartupError(err)
 			fmt.Fprintf(os.Stderr, "Failed to start daemon: %v\n", err)
 			return 1
 		}
 		return 0
 	}
 
-	// Auto-detect mode: requires exactly 1 file argument
-	if len(args) != 1 {
-		fmt.Fprintf(os.Stderr, "Usage: lum <path-to-markdown-file> [--port PORT]\n")
+	// Auto-detect mode: requires at least 1 file argument
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: lum <path-to-markdown-file>... [--port PORT]\n")
 		return 1
 	}
 
-	absPath, err := filepath.Abs(args[0])
+	docs, failures, err := readDocuments(args, opts.filter, os.Stdin)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to get absolute path: %v\n", err)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		return 1
 	}
-
-	if _, err := os.Stat(absPath); os.IsNotExist(err) {
-		fmt.Fprintf(os.Stderr, "File does not exist: %s\n", absPath)
+	printAddResults(os.Stdout, os.Stderr, failures)
+	if docs.empty() {
 		return 1
 	}
 
 	// Try to add to existing daemon
-	url, err := tryAddToExistingServer(absPath)
+	results, err := addDocumentsToExistingServer(docs)
 	if err == nil {
 		// Added to existing daemon
-		fmt.Println(url)
+		warnIgnoredPort(opts)
+		if printAddResults(os.Stdout, os.Stderr, results) > 0 || len(failures) > 0 {
+			return 1
+		}
 		return 0
 	}
 
-	// No daemon running - start in one-off mode
-	if err := startOneOff(port, absPath); err != nil {
+	// Only start a one-off server when there is none, a server that cannot be used must not be replaced
+	if !errors.Is(err, errNoServer) {
+		fmt.Fprintf(os.Stderr, "Failed to add files to the running server: %v\n", err)
+		return 1
+	}
+
+	// No daemon running - start in one-off mode, which only logs with -v
+	if opts.verbose {
+		setupLogger(os.Stderr)
+	} else {
+		setupLogger(io.Discard)
+	}
+	if err := startOneOff(opts, docs); err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to start server: %v\n", err)
 		return 1
 	}
 	return 0
 }
 
-// daemonize re-executes the current process as a daemon
-func daemonize(port int, initialFile string) error {
+// warnIgnoredPort warns when --port asks for another port than the running server is bound to. The server keeps
+// serving on its port, files are added to it as usual.
+func warnIgnoredPort(opts *options) {
+	if !opts.portSet || opts.port == 0 {
+		return
+	}
+
+	status, err := getExistingServerStatus()
+	if err != nil {
+		return
+	}
+	_, port, err := net.SplitHostPort(status.Address)
+	if err != nil || port == strconv.Itoa(opts.port) {
+		return
+	}
+
+	fmt.Fprintf(
+		os.Stderr,
+		"Warning: the running server is bound to port %s, --port %d is ignored. Run 'lum --stop' first to change it.\n",
+		port, opts.port,
+	)
+}
+
+// addToStartedDaemon adds the documents to a daemon that has just been started or promoted and prints their
+// URLs, or the daemon's URL if there are none. Returns the exit status, which is 1 if any document failed,
+// including the failures counted before the daemon was started.
+func addToStartedDaemon(url string, docs documents, failed int) int {
+	if docs.empty() {
+		fmt.Println(url)
+		if failed > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	results, err := addDocumentsToExistingServer(docs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to add files to daemon: %v\n", err)
+		return 1
+	}
+	if printAddResults(os.Stdout, os.Stderr, results)+failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// printVersion writes the version of this lum and of the running daemon, if any
+func printVersion(w io.Writer) {
+	fmt.Fprintf(w, "lum %s (control protocol %d)\n", Version, protocolVersion)
+
+	// The daemon version is printed below, a mismatch does not need a separate warning
+	versionWarnings = nil
+
+	client, err := dialControl()
+	switch {
+	case errors.Is(err, errLegacyServer):
+		fmt.Fprintf(w, "daemon: unknown version (legacy control protocol)\n")
+	case errors.Is(err, errIncompatibleServer):
+		fmt.Fprintf(w, "daemon: %v\n", err)
+	case err != nil:
+		fmt.Fprintf(w, "daemon: not running\n")
+	default:
+		defer closeControlClient(client)
+		fmt.Fprintf(w, "daemon: lum %s (control protocol %d)\n", client.version, client.protocol)
+		if client.version != Version {
+			fmt.Fprintf(w, "The daemon runs a different version, run 'lum --restart' to switch it to this one.\n")
+		}
+	}
+}
+
+// printFileList writes the tracked files either as a table or as JSON
+func printFileList(w io.Writer, list []trackedFileInfo, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(list)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "PATH\tSTATUS\tVIEWERS\tRENDERED\tURL"); err != nil {
+		return err
+	}
+	for _, info := range list {
+		rendered := "-"
+		if !info.RenderedAt.IsZero() {
+			rendered = info.RenderedAt.Local().Format(time.DateTime)
+		}
+		status := info.Status
+		if info.Unsaved {
+			status += " (unsaved)"
+		}
+		if _, err := fmt.Fprintf(
+			tw, "%s\t%s\t%d\t%s\t%s\n", info.Path, status, info.Viewers, rendered, info.URL,
+		); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// printInstanceList writes the running instances either as a table or as JSON
+func printInstanceList(w io.Writer, instances []daemonStatus, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(instances)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "INSTANCE\tPID\tADDRESS\tVERSION\tSTARTED"); err != nil {
+		return err
+	}
+	for _, status := range instances {
+		if _, err := fmt.Fprintf(
+			tw, "%s\t%d\thttp://%s\t%s\t%s\n",
+			status.Instance, status.PID, status.Address, status.Version, status.StartedAt.Local().Format(time.DateTime),
+		); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// printStatus writes the daemon status either as aligned key-value pairs or as JSON
+func printStatus(w io.Writer, status *daemonStatus, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(status)
+	}
+
+	uptime := time.Since(status.StartedAt).Truncate(time.Second)
+
+	// Daemons running in the foreground have no log file
+	logPath := status.LogPath
+	if logPath == "" {
+		logPath = "stderr"
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	rows := [][2]string{
+		{"Instance", status.Instance},
+		{"Mode", status.Mode},
+		{"PID", strconv.Itoa(status.PID)},
+		{"Address", "http://" + status.Address},
+		{"Version", status.Version},
+		{"Started", fmt.Sprintf("%s (up %s)", status.StartedAt.Local().Format(time.DateTime), uptime)},
+		{"Log", logPath},
+		{"Socket", status.SocketPath},
+	}
+	// Servers predating the HTTP API report no token
+	if status.TokenPath != "" {
+		rows = append(rows, [2]string{"API token", status.TokenPath})
+	}
+	for _, row := range rows {
+		if _, err := fmt.Fprintf(tw, "%s:\t%s\n", row[0], row[1]); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// daemonize re-executes the current process as a daemon and waits until it is serving. If listener is
+// not nil, the daemon serves on it instead of binding the port itself.
+// Returns the URL the daemon serves at, or the error the daemon failed to start with.
+func daemonize(opts *options, listener *os.File) (string, error) {
 	// Build command to re-execute ourselves
 	var args []string
 
@@ -223,19 +762,63 @@ func daemonize(port int, initialFile string) error {
 				}
 			}
 		}
+		// Only run the entry point test, otherwise the child would run the whole test suite
+		// and keep running after the daemon has stopped
+		if !slices.ContainsFunc(args, func(arg string) bool { return strings.HasPrefix(arg, "-test.run") }) {
+			args = append(args, "-test.run=^TestRunMain$")
+		}
 		// Add separator
 		args = append(args, "--")
 	}
 
-	args = append(args, "--daemon", "--port", fmt.Sprintf("%d", port))
-	if initialFile != "" {
-		args = append(args, initialFile)
+	args = append(args, "--daemon", "--port", fmt.Sprintf("%d", opts.port))
+	if opts.host != "" && opts.host != defaultHost {
+		args = append(args, "--host", opts.host)
+	}
+	if opts.instance != defaultInstance {
+		args = append(args, "--instance", opts.instance)
+	}
+	if opts.idleTimeout > 0 {
+		args = append(args, "--idle-timeout", opts.idleTimeout.String())
+	}
+	if opts.noRestore {
+		args = append(args, "--no-restore")
+	}
+	if opts.logLevel != "" {
+		args = append(args, "--log-level", opts.logLevel)
+	}
+	if opts.logFormat != "" && opts.logFormat != logFormatText {
+		args = append(args, "--log-format", opts.logFormat)
+	}
+	if opts.accessLog {
+		args = append(args, "--access-log")
+	}
+	if opts.logMaxSize != defaultLogMaxSize {
+		args = append(args, "--log-max-size", strconv.FormatInt(opts.logMaxSize, 10))
+	}
+	if opts.logKeep != defaultLogKeep {
+		args = append(args, "--log-keep", strconv.Itoa(opts.logKeep))
 	}
 
 	cmd := exec.Command(os.Args[0], args...)
 
-	// Set environment variable to indicate this is the daemonized child
-	cmd.Env = append(os.Environ(), "LUM_DAEMONIZED=1")
+	// The child reports whether it started successfully over this pipe
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return "", fmt.Errorf("failed to create readiness pipe: %w", err)
+	}
+	defer func() { _ = readyReader.Close() }()
+
+	// Set environment variable to indicate this is the daemonized child.
+	// ExtraFiles start at file descriptor 3 in the child.
+	cmd.Env = append(os.Environ(), "LUM_DAEMONIZED=1", readyFDEnv+"=3")
+	cmd.ExtraFiles = []*os.File{readyWriter}
+	// Credentials are passed in the environment rather than on the command line, where other users could see them
+	cmd.Env = append(cmd.Env, tokenEnv+"="+opts.credentials.token, authEnv+"="+opts.credentials.basicAuth())
+	if listener != nil {
+		cmd.Env = append(cmd.Env, listenerFDEnv+"=4")
+		cmd.ExtraFiles = append(cmd.ExtraFiles, listener)
+	}
 
 	// Detach from parent
 	cmd.Stdin = nil
@@ -245,11 +828,17 @@ func daemonize(port int, initialFile string) error {
 		Setsid: true, // Create new session
 	}
 
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start daemon process: %w", err)
+	err = cmd.Start()
+	// Only the child holds the write end now, so reading sees EOF if it exits without reporting
+	_ = readyWriter.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to start daemon process: %w", err)
+	}
+	if err := cmd.Process.Release(); err != nil {
+		slog.Error("Failed to release daemon process", "error", err)
 	}
 
-	return nil
+	return waitForReady(readyReader, readyTimeout)
 }
 
 // daemonExists checks if a daemon is already running
@@ -259,8 +848,8 @@ func daemonExists() bool {
 		return false
 	}
 
-	// Try to connect to the socket to verify daemon is actually running
-	conn, err := net.Dial("unix", socketPath)
+	// Try to connect to the socket to verify daemon is actually running, removing it if it is stale
+	conn, err := dialSocket(socketPath)
 	if err != nil {
 		return false
 	}
@@ -268,17 +857,115 @@ func daemonExists() bool {
 	return true
 }
 
-// stopDaemon sends a STOP command to the running daemon
-func stopDaemon() error {
+// stopDaemon asks the running daemon to shut down and waits for its process to exit. A daemon that does not
+// answer on its control socket is sent SIGTERM instead. With force, a daemon that does not exit in time is killed.
+func stopDaemon(force bool) error {
 	socketPath, err := getSocketPath()
 	if err != nil {
 		return fmt.Errorf("failed to get socket path: %w", err)
 	}
 
-	if _, err := os.Stat(socketPath); os.IsNotExist(err) {
-		return fmt.Errorf("no daemon running")
+	// Servers that cannot speak the JSON protocol with us still understand the legacy STOP command
+	client, err := dialControl()
+	if errors.Is(err, errLegacyServer) || errors.Is(err, errIncompatibleServer) {
+		return stopLegacyDaemon(socketPath)
+	}
+	if err != nil {
+		return stopByPID(socketPath, err, force)
+	}
+	defer closeControlClient(client)
+
+	var result stopResult
+	if err := client.call("stop", nil, &result); err != nil {
+		return fmt.Errorf("failed to send stop command: %w", err)
+	}
+
+	// The daemon acknowledged the request, wait for it to finish shutting down
+	return waitForStop(socketPath, result.PID, stopTimeout, force)
+}
+
+// restartExistingDaemon restarts the running daemon on the binary currently installed and returns the status of
+// the new daemon. The new daemon takes over the listening socket and the tracked files. Daemons that cannot hand
+// over are stopped and started again instead, and restore their files from the state file. opts are the options
+// given with --restart, used for the settings such a daemon does not report.
+func restartExistingDaemon(opts *options) (*daemonStatus, error) {
+	oldStatus, err := getExistingServerStatus()
+	if err != nil {
+		return nil, fmt.Errorf("no daemon reachable: %w", err)
 	}
 
+	var cerr *controlError
+	err = callExistingServer("restart", nil, &handOverResult{})
+	switch {
+	case errors.As(err, &cerr) && cerr.Code == errCodeUnknownMethod:
+		if err := restartByStopping(oldStatus, opts); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	default:
+		// The new daemon is already serving, wait for the old one to finish shutting down
+		if err := waitForExit(oldStatus.PID, stopTimeout); err != nil {
+			return nil, err
+		}
+	}
+
+	return getExistingServerStatus()
+}
+
+// restartByStopping stops the running daemon and starts a new one on the same address with the same settings
+func restartByStopping(oldStatus *daemonStatus, opts *options) error {
+	restartOpts, err := restartOptions(oldStatus, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := stopDaemon(false); err != nil {
+		return fmt.Errorf("failed to stop daemon: %w", err)
+	}
+
+	if _, err := daemonize(restartOpts, nil); err != nil {
+		return fmt.Errorf("failed to start daemon: %w", err)
+	}
+
+	return nil
+}
+
+// restartOptions returns the options to start the successor of the daemon described by oldStatus with. It keeps
+// the daemon's address, the other settings are the ones given in opts.
+func restartOptions(oldStatus *daemonStatus, opts *options) (*options, error) {
+	host, portValue, err := net.SplitHostPort(oldStatus.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get daemon address: %w", err)
+	}
+	port, err := strconv.Atoi(portValue)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get daemon port: %w", err)
+	}
+
+	restartOpts := &options{
+		port:        port,
+		host:        host,
+		credentials: opts.credentials,
+		instance:    instanceName,
+		idleTimeout: opts.idleTimeout,
+		logLevel:    opts.logLevel,
+		logFormat:   opts.logFormat,
+		accessLog:   opts.accessLog,
+		logMaxSize:  opts.logMaxSize,
+		logKeep:     opts.logKeep,
+	}
+
+	// Access credentials are never reported, they must be given again before the daemon is stopped
+	if err := checkExposure(oldStatus.Address, restartOpts.credentials); err != nil {
+		return nil, err
+	}
+
+	return restartOpts, nil
+}
+
+// stopLegacyDaemon sends a STOP command to a daemon that only speaks the legacy text protocol
+func stopLegacyDaemon(socketPath string) error {
 	conn, err := dialSocket(socketPath)
 	if err != nil {
 		return fmt.Errorf("failed to connect to daemon: %w", err)
@@ -296,32 +983,74 @@ func stopDaemon() error {
 	return nil
 }
 
-// startDaemon initializes and starts a daemon instance
-func startDaemon(port int, initialFile string) error {
-	// Setup log file
-	if err := setupLogFile(); err != nil {
+// startDaemon initializes and starts a daemon instance. Initial documents that cannot be added are logged and
+// skipped.
+func startDaemon(opts *options, initial documents) error {
+	port := opts.port
+
+	// Setup log file, a daemon in the foreground keeps logging to stderr
+	if opts.foreground {
+		setupLogger(os.Stderr)
+		slog.Info("Daemon starting in the foreground")
+	} else if err := setupLogFile(); err != nil {
 		return fmt.Errorf("failed to setup log file: %w", err)
 	}
 
+	// Use the sockets passed by systemd socket activation instead of creating them
+	if err := inheritActivatedListeners(); err != nil {
+		return err
+	}
+
+	path, err := getStatePath()
+	if err != nil {
+		return err
+	}
+	stateLock.Lock()
+	statePath = path
+	stateLock.Unlock()
+
+	// Bind the HTTP port before answering control clients, so that they are told the port actually in use
+	listener := activatedHTTP
+	if listener == nil {
+		listener, err = listenDaemonHTTP(opts.host, port)
+		if err != nil {
+			return fmt.Errorf("failed to start server: %w", err)
+		}
+	}
+	// A listener passed by systemd or the previous daemon may be bound to any address
+	if err := checkExposure(listener.Addr().String(), opts.credentials); err != nil {
+		_ = listener.Close()
+		return err
+	}
+	setCredentials(opts.credentials)
+	serverListener = listener
+	serverAddr = listener.Addr().String()
+	port = serverPort()
+
 	// Start control socket
-	if err := startControlSocket(port); err != nil {
+	if err := startControlSocket(); err != nil {
 		return fmt.Errorf("failed to start control socket: %w", err)
 	}
+	defer cleanupSocket()
 
-	// Setup cleanup on exit
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-sigChan
-		log.Println("Shutting down...")
-		cleanupSocket()
-		os.Exit(0)
-	}()
+	// Restore the files served before the last shutdown, or start with a fresh state
+	if opts.noRestore {
+		saveState()
+	} else if err := restoreState(); err != nil {
+		slog.Error("Failed to restore state", "error", err)
+	}
 
-	// Add initial file if provided
-	if initialFile != "" {
-		if err := addFile(initialFile); err != nil {
-			return fmt.Errorf("failed to add initial file: %w", err)
+	// Add initial files if provided
+	for _, initialFile := range initial.paths {
+		if err := addPath(initialFile, initial.filter); err != nil {
+			slog.Error("Failed to add initial file", "path", initialFile, "error", err)
+			continue
+		}
+		slog.Info("Serving initial file", "path", initialFile)
+	}
+	if initial.stdin != nil {
+		if _, err := addStdinDocument(initial.stdin); err != nil {
+			slog.Error("Failed to add document from stdin", "error", err)
 		}
 	}
 
@@ -330,30 +1059,131 @@ func startDaemon(port int, initialFile string) error {
 	mux.HandleFunc("/", handleIndex)
 	mux.HandleFunc("/events", handleSSE)
 	mux.HandleFunc("/events/index", handleIndexSSE)
+	mux.Handle("/api/", apiHandler())
+
+	serverMode = modeDaemon
+	daemonOptions = &options{
+		port:        port,
+		host:        opts.host,
+		credentials: opts.credentials,
+		instance:    instanceName,
+		idleTimeout: opts.idleTimeout,
+		foreground:  opts.foreground,
+		logLevel:    opts.logLevel,
+		logFormat:   opts.logFormat,
+		accessLog:   opts.accessLog,
+		logMaxSize:  opts.logMaxSize,
+		logKeep:     opts.logKeep,
+	}
+	startedAt = time.Now()
+
+	if opts.idleTimeout > 0 {
+		slog.Info("Exiting when idle", "timeout", opts.idleTimeout)
+		go watchIdle(opts.idleTimeout, requestShutdown)
+	}
+	slog.Info("Daemon started", "url", "http://"+serverAddr)
+	for _, url := range networkURLs(opts.host, port) {
+		slog.Info("Reachable from the network", "url", url)
+	}
+	reportReady(indexURL(port))
 
-	addr := fmt.Sprintf("127.0.0.1:%d", port)
-	log.Printf("Daemon started on http://%s", addr)
-	if initialFile != "" {
-		log.Printf("Serving %s", initialFile)
+	if err := serveUntilShutdown(listener, withAccessLog(withAuth(mux)), shutdownChan); err != nil {
+		return err
 	}
 
-	// TODO: Daemonize (detach from terminal)
+	slog.Info("Daemon stopped")
+	return nil
+}
+
+// listenDaemonHTTP binds the daemon's HTTP port on host. With port 0 any free port is used, preferring
+// the port the daemon was bound to last time, so that open pages and bookmarks keep working.
+func listenDaemonHTTP(host string, port int) (net.Listener, error) {
+	if port == 0 && os.Getenv(listenerFDEnv) == "" {
+		if saved := loadStatePort(); saved != 0 {
+			if listener, err := net.Listen("tcp", bindAddr(host, saved)); err == nil {
+				return listener, nil
+			}
+		}
+	}
+
+	return listenHTTP(bindAddr(host, port))
+}
 
-	if err := http.ListenAndServe(addr, mux); err != nil {
+// serveUntilShutdown serves HTTP requests on listener until the process receives SIGINT or SIGTERM,
+// or until shutdown is signalled (by a STOP control command), and then shuts the server down gracefully:
+// browsers are told the server is stopping, file watchers are closed and open connections are drained.
+func serveUntilShutdown(listener net.Listener, handler http.Handler, shutdown <-chan struct{}) error {
+	server := &http.Server{Handler: handler}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	select {
+	case err := <-serveErr:
 		return fmt.Errorf("server failed: %w", err)
+	case sig := <-sigChan:
+		slog.Info("Received signal, shutting down", "signal", sig.String())
+	case <-shutdown:
+	}
+
+	// SSE handlers return after delivering this event, which lets Shutdown drain their connections
+	notifyAllClients("server-stopping")
+	stopWatchingFiles()
+	stopWatchingDirectories()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("Graceful shutdown timed out, closing remaining connections", "error", err)
+		if err := server.Close(); err != nil {
+			slog.Error("Failed to close server", "error", err)
+		}
 	}
 
 	return nil
 }
 
-// startOneOff starts a simple one-off server for a single file
-func startOneOff(port int, filePath string) error {
-	// Suppress all log output in one-off mode
-	log.SetOutput(io.Discard)
+// requestShutdown asks the running server to shut down gracefully
+func requestShutdown() {
+	select {
+	case shutdownChan <- struct{}{}:
+	default:
+		// A shutdown is already pending
+	}
+}
+
+// startOneOff starts a simple one-off server for the given documents. Documents that cannot be added are
+// reported and skipped. It also listens on the control socket, so that later invocations can add files to it
+// or promote it to a daemon.
+func startOneOff(opts *options, docs documents) error {
+	port := opts.port
 
-	// Add the file
-	if err := addFile(filePath); err != nil {
-		return fmt.Errorf("failed to add file: %w", err)
+	// Add the files
+	var added []string
+	for _, filePath := range docs.paths {
+		if err := addPath(filePath, docs.filter); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to add %s: %v\n", filePath, err)
+			continue
+		}
+		added = append(added, filePath)
+	}
+	if docs.stdin != nil {
+		id, err := addStdinDocument(docs.stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to add %s: %v\n", stdinLabel, err)
+		} else {
+			added = append(added, id)
+		}
+	}
+	if len(added) == 0 {
+		return fmt.Errorf("none of the files could be added")
 	}
 
 	// Setup HTTP handlers
@@ -361,21 +1191,49 @@ func startOneOff(port int, filePath string) error {
 	mux.HandleFunc("/", handleIndex)
 	mux.HandleFunc("/events", handleSSE)
 	mux.HandleFunc("/events/index", handleIndexSSE)
+	mux.Handle("/api/", apiHandler())
 
 	// Try to create listener first to check if port is available
-	addr := fmt.Sprintf("127.0.0.1:%d", port)
-	listener, err := net.Listen("tcp", addr)
+	listener, err := net.Listen("tcp", bindAddr(opts.host, port))
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return fmt.Errorf(
+			"port %d is already in use by another process (use --port to pick another one, or --port auto)", port,
+		)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to start server: %w", err)
 	}
+	setCredentials(opts.credentials)
+	serverListener = listener
+	serverAddr = listener.Addr().String()
 
-	// Port is available, print URL
-	url := fmt.Sprintf("http://%s/?file=%s", addr, filePath)
-	fmt.Println(url)
+	// Only take over the control socket once the port is ours
+	if err := startControlSocket(); err != nil {
+		return fmt.Errorf("failed to start control socket: %w", err)
+	}
+	defer cleanupSocket()
 
-	// Start serving
-	if err := http.Serve(listener, mux); err != nil {
-		return fmt.Errorf("server failed: %w", err)
+	serverMode = modeOneOff
+	startedAt = time.Now()
+
+	// Port is available, print the URLs with the port actually bound. Directories are listed on the index page.
+	port = serverPort()
+	for _, filePath := range added {
+		if isTrackedDirectory(filePath) {
+			fmt.Println(indexURL(port))
+		} else {
+			fmt.Println(fileURL(port, filePath))
+		}
+	}
+	printNetworkURLs(os.Stdout, opts.host, indexURL(port))
+
+	// Serve until interrupted, stopped or promoted to a daemon
+	if err := serveUntilShutdown(listener, withAccessLog(withAuth(mux)), shutdownChan); err != nil {
+		return err
+	}
+
+	if handedOff {
+		fmt.Fprintf(os.Stderr, "Handed over to a daemon, which keeps serving the files\n")
 	}
 
 	return nil
@@ -2,17 +2,38 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 )
 
+// TestMain keeps daemons started by the tests from restoring or overwriting the user's state file. The test binary
+// run as lum by the integration tests (with "--" before its arguments) is given a state directory by its test.
+func TestMain(m *testing.M) {
+	if slices.Contains(os.Args, "--") {
+		os.Exit(m.Run())
+	}
+
+	stateHome, err := os.MkdirTemp("", "lum-state-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create state directory: %v\n", err)
+		os.Exit(1)
+	}
+	_ = os.Setenv("XDG_STATE_HOME", stateHome)
+
+	code := m.Run()
+	_ = os.RemoveAll(stateHome)
+	os.Exit(code)
+}
+
 // TestMultiFileEndToEnd tests the complete multi-file workflow
 func TestMultiFileEndToEnd(t *testing.T) {
 	// Create temporary directory for test files
@@ -35,7 +56,7 @@ func TestMultiFileEndToEnd(t *testing.T) {
 	// Start server in background
 	done := make(chan error, 1)
 	go func() {
-		done <- startDaemon(port, file1)
+		done <- startDaemon(&options{port: port}, documents{paths: []string{file1}})
 	}()
 
 	// Give server time to start
@@ -211,7 +232,7 @@ func TestControlSocketProtocol(t *testing.T) {
 
 	// Start server
 	go func() {
-		_ = startDaemon(port, testFile)
+		_ = startDaemon(&options{port: port}, documents{paths: []string{testFile}})
 	}()
 
 	time.Sleep(500 * time.Millisecond)
@@ -382,10 +403,25 @@ func TestStartOneOff(t *testing.T) {
 
 	port := 16400
 
+	// Isolate the control socket of the one-off server
+	tmpRuntimeDir := t.TempDir()
+	oldXDG := os.Getenv("XDG_RUNTIME_DIR")
+	if err := os.Setenv("XDG_RUNTIME_DIR", tmpRuntimeDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cleanupSocket()
+		if oldXDG != "" {
+			_ = os.Setenv("XDG_RUNTIME_DIR", oldXDG)
+		} else {
+			_ = os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+	})
+
 	// Start server in goroutine
 	done := make(chan error, 1)
 	go func() {
-		done <- startOneOff(port, testFile)
+		done <- startOneOff(&options{port: port}, documents{paths: []string{testFile}})
 	}()
 
 	// Give server time to start
@@ -403,6 +439,31 @@ func TestStartOneOff(t *testing.T) {
 		t.Errorf("Expected status 200, got %d", resp.StatusCode)
 	}
 
+	// Later invocations reach the one-off server over the control socket
+	otherFile := filepath.Join(tmpDir, "other.md")
+	if err := os.WriteFile(otherFile, []byte("# Other"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = removeFile(otherFile)
+	})
+
+	addedURL, err := tryAddToExistingServer(otherFile)
+	if err != nil {
+		t.Fatalf("Failed to add file to one-off server: %v", err)
+	}
+	if expected := fileURL(port, otherFile); addedURL != expected {
+		t.Errorf("Expected URL %s, got %s", expected, addedURL)
+	}
+
+	status, err := getExistingServerStatus()
+	if err != nil {
+		t.Fatalf("Failed to get one-off server status: %v", err)
+	}
+	if status.Mode != modeOneOff {
+		t.Errorf("Expected mode %s, got %s", modeOneOff, status.Mode)
+	}
+
 	// Cleanup
 	filesLock.Lock()
 	if fs, ok := files[testFile]; ok {
@@ -414,6 +475,96 @@ func TestStartOneOff(t *testing.T) {
 	filesLock.Unlock()
 }
 
+// TestServeUntilShutdown tests that a shutdown request notifies SSE clients and stops the server
+func TestServeUntilShutdown(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.md")
+
+	if err := os.WriteFile(testFile, []byte("# Test"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := addFile(testFile); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		filesLock.Lock()
+		delete(files, testFile)
+		filesLock.Unlock()
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:16502")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", handleSSE)
+
+	shutdown := make(chan struct{}, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- serveUntilShutdown(listener, mux, shutdown)
+	}()
+
+	// Request the shutdown once the SSE client below is registered
+	go func() {
+		deadline := time.Now().Add(2 * time.Second)
+		for viewerCount(testFile) == 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		time.Sleep(50 * time.Millisecond)
+		shutdown <- struct{}{}
+	}()
+
+	resp, err := http.Get("http://127.0.0.1:16502/events?file=" + testFile)
+	if err != nil {
+		t.Fatalf("Failed to connect to SSE endpoint: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read SSE event: %v", err)
+	}
+	if line != "data: server-stopping\n" {
+		t.Errorf("Expected server-stopping event, got %q", line)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected clean shutdown, got: %v", err)
+		}
+	case <-time.After(shutdownTimeout + time.Second):
+		t.Fatal("Server did not shut down")
+	}
+
+	if _, err := http.Get("http://127.0.0.1:16502/events?file=" + testFile); err == nil {
+		t.Error("Expected server to stop accepting connections")
+	}
+
+	filesLock.RLock()
+	watcher := files[testFile].watcher
+	filesLock.RUnlock()
+	if watcher != nil {
+		t.Error("Expected file watcher to be closed")
+	}
+}
+
+// viewerCount returns the number of SSE clients watching a tracked file
+func viewerCount(filePath string) int {
+	filesLock.RLock()
+	fileState, exists := files[filePath]
+	filesLock.RUnlock()
+	if !exists {
+		return 0
+	}
+
+	fileState.clientsLock.RLock()
+	defer fileState.clientsLock.RUnlock()
+	return len(fileState.sseClients)
+}
+
 // TestRunErrorPaths tests error handling in run()
 func TestRunErrorPaths(t *testing.T) {
 	t.Run("NoArguments", func(t *testing.T) {
@@ -439,7 +590,7 @@ func TestRunErrorPaths(t *testing.T) {
 			}
 		}()
 
-		err := stopDaemon()
+		err := stopDaemon(false)
 		if err == nil {
 			t.Error("Expected error when stopping non-existent daemon")
 		}
@@ -449,6 +600,227 @@ func TestRunErrorPaths(t *testing.T) {
 		}
 	})
 
+	t.Run("RemoveFlagWithoutArgument", func(t *testing.T) {
+		_, _, err := parseArgs([]string{"--remove"})
+		if err == nil {
+			t.Error("Expected error when --remove has no argument")
+		}
+	})
+
+	t.Run("InvalidIdleTimeout", func(t *testing.T) {
+		for _, value := range []string{"soon", "0s", "-5m"} {
+			if _, _, err := parseArgs([]string{"--idle-timeout", value}); err == nil {
+				t.Errorf("Expected error for idle timeout %q", value)
+			}
+		}
+
+		opts, _, err := parseArgs([]string{"--idle-timeout", "30m"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.idleTimeout != 30*time.Minute {
+			t.Errorf("Expected idle timeout of 30m, got %s", opts.idleTimeout)
+		}
+	})
+
+	t.Run("LogOptions", func(t *testing.T) {
+		opts, _, err := parseArgs([]string{"-v", "--log-level", "debug", "--log-format", "json", "--access-log"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !opts.verbose || opts.logLevel != "debug" || opts.logFormat != logFormatJSON || !opts.accessLog {
+			t.Errorf("Unexpected log options: %+v", opts)
+		}
+
+		if _, _, err := parseArgs([]string{"--log-level", "loud"}); err == nil {
+			t.Error("Expected error for invalid log level")
+		}
+		if _, _, err := parseArgs([]string{"--log-format", "xml"}); err == nil {
+			t.Error("Expected error for invalid log format")
+		}
+	})
+
+	t.Run("LogsOptions", func(t *testing.T) {
+		opts, _, err := parseArgs([]string{"--logs", "-f", "--log-max-size", "1M", "--log-keep", "0"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !opts.logs || !opts.follow || opts.logMaxSize != 1<<20 || opts.logKeep != 0 {
+			t.Errorf("Unexpected log options: %+v", opts)
+		}
+
+		opts, _, err = parseArgs([]string{"--logs"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.logMaxSize != defaultLogMaxSize || opts.logKeep != defaultLogKeep {
+			t.Errorf("Expected default rotation settings, got %d bytes and %d logs", opts.logMaxSize, opts.logKeep)
+		}
+
+		for _, args := range [][]string{
+			{"--follow"},
+			{"--log-keep", "-1"},
+			{"--log-max-size", "huge"},
+		} {
+			if _, _, err := parseArgs(args); err == nil {
+				t.Errorf("Expected error for %v", args)
+			}
+		}
+	})
+
+	t.Run("DirectoryFilter", func(t *testing.T) {
+		args := []string{"docs", "--include", "*.md", "--exclude", "drafts", "--exclude", "**/tmp"}
+		opts, args, err := parseArgs(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(args) != 1 || args[0] != "docs" {
+			t.Errorf("Expected the directory argument, got %v", args)
+		}
+		if len(opts.filter.Include) != 1 || len(opts.filter.Exclude) != 2 || opts.filter.Exclude[1] != "**/tmp" {
+			t.Errorf("Unexpected filter: %+v", opts.filter)
+		}
+
+		for _, args := range [][]string{{"--include"}, {"--exclude", "[bad"}} {
+			if _, _, err := parseArgs(args); err == nil {
+				t.Errorf("Expected error for %v", args)
+			}
+		}
+	})
+
+	t.Run("Stdin", func(t *testing.T) {
+		_, args, err := parseArgs([]string{"-", "README.md"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(args) != 2 || args[0] != stdinArg {
+			t.Errorf("Expected %q to be a file argument, got %v", stdinArg, args)
+		}
+	})
+
+	t.Run("AutomaticPort", func(t *testing.T) {
+		for _, value := range []string{"0", "auto"} {
+			opts, _, err := parseArgs([]string{"--port", value})
+			if err != nil {
+				t.Fatalf("Unexpected error for port %q: %v", value, err)
+			}
+			if opts.port != 0 {
+				t.Errorf("Expected port 0 for %q, got %d", value, opts.port)
+			}
+		}
+
+		for _, value := range []string{"-1", "65536", "any"} {
+			if _, _, err := parseArgs([]string{"--port", value}); err == nil {
+				t.Errorf("Expected error for port %q", value)
+			}
+		}
+	})
+
+	t.Run("Host", func(t *testing.T) {
+		opts, _, err := parseArgs([]string{"--host", "0.0.0.0", "--token", "s3cret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.host != "0.0.0.0" || opts.credentials.token != "s3cret" {
+			t.Errorf("Unexpected host options: %+v", opts)
+		}
+
+		opts, _, err = parseArgs([]string{"--host", "[::1]"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.host != "::1" {
+			t.Errorf("Expected the brackets to be stripped, got %q", opts.host)
+		}
+
+		opts, _, err = parseArgs([]string{"--host", "::", "--auth", "alice:secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.credentials.user != "alice" || opts.credentials.password != "secret" {
+			t.Errorf("Unexpected credentials: %+v", opts.credentials)
+		}
+
+		for _, args := range [][]string{
+			{"--host", "0.0.0.0"},
+			{"--host", "192.168.1.20"},
+			{"--host", ""},
+			{"--auth", "alice"},
+			{"--token"},
+		} {
+			if _, _, err := parseArgs(args); err == nil {
+				t.Errorf("Expected error for %v", args)
+			}
+		}
+	})
+
+	t.Run("ForegroundWithoutDaemon", func(t *testing.T) {
+		if _, _, err := parseArgs([]string{"--foreground"}); err == nil {
+			t.Error("Expected error when --foreground is used without --daemon")
+		}
+
+		opts, _, err := parseArgs([]string{"--daemon", "--foreground"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !opts.daemon || !opts.foreground {
+			t.Error("Expected --daemon and --foreground to be set")
+		}
+	})
+
+	t.Run("ForceWithoutStop", func(t *testing.T) {
+		if _, _, err := parseArgs([]string{"--force"}); err == nil {
+			t.Error("Expected error when --force is used without --stop")
+		}
+
+		opts, _, err := parseArgs([]string{"--stop", "--force"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !opts.stop || !opts.force {
+			t.Error("Expected --stop and --force to be set")
+		}
+	})
+
+	t.Run("InvalidInstanceName", func(t *testing.T) {
+		_, _, err := parseArgs([]string{"--instance", "../etc"})
+		if err == nil {
+			t.Error("Expected error for invalid instance name")
+		}
+	})
+
+	t.Run("RemoveWhenNoneRunning", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		if err := os.Setenv("XDG_RUNTIME_DIR", tmpDir); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := os.Unsetenv("XDG_RUNTIME_DIR"); err != nil {
+				t.Logf("Failed to unset XDG_RUNTIME_DIR: %v", err)
+			}
+		}()
+
+		if err := removeFromExistingServer("/tmp/test.md"); err == nil {
+			t.Error("Expected error when removing from non-existent daemon")
+		}
+	})
+
+	t.Run("StatusWhenNoneRunning", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		if err := os.Setenv("XDG_RUNTIME_DIR", tmpDir); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := os.Unsetenv("XDG_RUNTIME_DIR"); err != nil {
+				t.Logf("Failed to unset XDG_RUNTIME_DIR: %v", err)
+			}
+		}()
+
+		if _, err := getExistingServerStatus(); err == nil {
+			t.Error("Expected error when querying status of non-existent daemon")
+		}
+	})
+
 	t.Run("DaemonExistsCheck", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		if err := os.Setenv("XDG_RUNTIME_DIR", tmpDir); err != nil {
@@ -466,3 +838,225 @@ func TestRunErrorPaths(t *testing.T) {
 		}
 	})
 }
+
+// TestPrintFileList tests the output of --list
+func TestPrintFileList(t *testing.T) {
+	list := []trackedFileInfo{
+		{
+			Path:    "/tmp/a.md",
+			URL:     "http://localhost:6333/?file=/tmp/a.md",
+			Status:  "ok",
+			Viewers: 2,
+		},
+		{
+			Path:    "/tmp/b.md",
+			URL:     "http://localhost:6333/?file=/tmp/b.md",
+			Status:  "ok",
+			Unsaved: true,
+		},
+	}
+
+	t.Run("Table", func(t *testing.T) {
+		var buf strings.Builder
+		if err := printFileList(&buf, list, false); err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 3 {
+			t.Fatalf("Expected header and two rows, got:\n%s", buf.String())
+		}
+		if !strings.HasPrefix(lines[0], "PATH") {
+			t.Errorf("Expected header row, got: %s", lines[0])
+		}
+		for _, field := range []string{"/tmp/a.md", "ok", "2", "http://localhost:6333/?file=/tmp/a.md"} {
+			if !strings.Contains(lines[1], field) {
+				t.Errorf("Expected row to contain %q, got: %s", field, lines[1])
+			}
+		}
+		if !strings.Contains(lines[2], "ok (unsaved)") {
+			t.Errorf("Expected the unsaved buffer to be marked, got: %s", lines[2])
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var buf strings.Builder
+		if err := printFileList(&buf, list, true); err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded []trackedFileInfo
+		if err := json.Unmarshal([]byte(buf.String()), &decoded); err != nil {
+			t.Fatalf("Output is not valid JSON: %v\n%s", err, buf.String())
+		}
+		if len(decoded) != 2 || decoded[0].Path != "/tmp/a.md" || decoded[0].Viewers != 2 || !decoded[1].Unsaved {
+			t.Errorf("Unexpected decoded list: %+v", decoded)
+		}
+	})
+}
+
+// TestPrintStatus tests the output of --status
+func TestPrintStatus(t *testing.T) {
+	status := &daemonStatus{
+		Instance:   "docs",
+		PID:        1234,
+		Address:    "127.0.0.1:6333",
+		Version:    "1.2.3",
+		StartedAt:  time.Now().Add(-time.Hour),
+		LogPath:    "/run/user/1000/lum/lum.log",
+		SocketPath: "/run/user/1000/lum/control.sock",
+	}
+
+	t.Run("Text", func(t *testing.T) {
+		var buf strings.Builder
+		if err := printStatus(&buf, status, false); err != nil {
+			t.Fatal(err)
+		}
+
+		output := buf.String()
+		for _, field := range []string{
+			"docs", "1234", "http://127.0.0.1:6333", "1.2.3", "up 1h0m0s",
+			"/run/user/1000/lum/lum.log", "/run/user/1000/lum/control.sock",
+		} {
+			if !strings.Contains(output, field) {
+				t.Errorf("Expected output to contain %q, got:\n%s", field, output)
+			}
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var buf strings.Builder
+		if err := printStatus(&buf, status, true); err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded daemonStatus
+		if err := json.Unmarshal([]byte(buf.String()), &decoded); err != nil {
+			t.Fatalf("Output is not valid JSON: %v\n%s", err, buf.String())
+		}
+		if decoded.PID != 1234 || decoded.Version != "1.2.3" {
+			t.Errorf("Unexpected decoded status: %+v", decoded)
+		}
+	})
+}
+
+func TestPrintInstanceList(t *testing.T) {
+	instances := []daemonStatus{
+		{Instance: "default", PID: 1234, Address: "127.0.0.1:6333", Version: "1.2.3", StartedAt: time.Now()},
+		{Instance: "notes", PID: 5678, Address: "127.0.0.1:6400", Version: "1.2.3", StartedAt: time.Now()},
+	}
+
+	t.Run("Table", func(t *testing.T) {
+		var buf strings.Builder
+		if err := printInstanceList(&buf, instances, false); err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 3 {
+			t.Fatalf("Expected header and 2 rows, got:\n%s", buf.String())
+		}
+		if !strings.HasPrefix(lines[0], "INSTANCE") {
+			t.Errorf("Expected header row, got %q", lines[0])
+		}
+		if !strings.Contains(lines[2], "notes") || !strings.Contains(lines[2], "http://127.0.0.1:6400") {
+			t.Errorf("Expected notes instance row, got %q", lines[2])
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var buf strings.Builder
+		if err := printInstanceList(&buf, instances, true); err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded []daemonStatus
+		if err := json.Unmarshal([]byte(buf.String()), &decoded); err != nil {
+			t.Fatalf("Output is not valid JSON: %v\n%s", err, buf.String())
+		}
+		if len(decoded) != 2 || decoded[1].Instance != "notes" {
+			t.Errorf("Unexpected decoded instances: %+v", decoded)
+		}
+	})
+}
+
+func TestPrintVersion(t *testing.T) {
+	tmpRuntimeDir := t.TempDir()
+	oldXDG := os.Getenv("XDG_RUNTIME_DIR")
+	if err := os.Setenv("XDG_RUNTIME_DIR", tmpRuntimeDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if oldXDG != "" {
+			_ = os.Setenv("XDG_RUNTIME_DIR", oldXDG)
+		} else {
+			_ = os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+	})
+
+	t.Run("NoDaemon", func(t *testing.T) {
+		var buf strings.Builder
+		printVersion(&buf)
+
+		expected := fmt.Sprintf("lum %s (control protocol %d)\ndaemon: not running\n", Version, protocolVersion)
+		if buf.String() != expected {
+			t.Errorf("Expected %q, got %q", expected, buf.String())
+		}
+	})
+
+	t.Run("RunningDaemon", func(t *testing.T) {
+		if err := startControlSocket(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(cleanupSocket)
+		time.Sleep(100 * time.Millisecond)
+
+		var buf strings.Builder
+		printVersion(&buf)
+
+		expected := fmt.Sprintf("daemon: lum %s (control protocol %d)\n", Version, protocolVersion)
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected output to contain %q, got %q", expected, buf.String())
+		}
+	})
+}
+
+func TestRestartOptions(t *testing.T) {
+	given := &options{
+		idleTimeout: time.Hour,
+		logLevel:    "warn",
+		logFormat:   logFormatText,
+		logMaxSize:  1 << 20,
+		logKeep:     1,
+	}
+
+	t.Run("GivenSettings", func(t *testing.T) {
+		opts, err := restartOptions(&daemonStatus{Address: "127.0.0.1:6333"}, given)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.port != 6333 || opts.host != "127.0.0.1" || opts.instance != instanceName {
+			t.Errorf("Expected the daemon's address, got %+v", opts)
+		}
+		if opts.idleTimeout != time.Hour || opts.logLevel != "warn" || opts.logMaxSize != 1<<20 || opts.logKeep != 1 {
+			t.Errorf("Expected the settings given with --restart, got %+v", opts)
+		}
+	})
+
+	t.Run("ExposedAddress", func(t *testing.T) {
+		status := &daemonStatus{Address: "[::]:6333"}
+		if _, err := restartOptions(status, given); err == nil {
+			t.Error("Expected error restarting a daemon bound to all interfaces without credentials")
+		}
+
+		withToken := *given
+		withToken.credentials = accessCredentials{token: "s3cret"}
+		opts, err := restartOptions(status, &withToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.host != "::" || opts.credentials.token != "s3cret" {
+			t.Errorf("Expected the daemon's host and the given credentials, got %+v", opts)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// defaultHost is the address the HTTP server binds to unless --host is given
+const defaultHost = "127.0.0.1"
+
+// normalizeHost strips the brackets of an IPv6 address given as [ADDR]
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// bindAddr returns the address to bind the HTTP server to, on the default host if host is empty
+func bindAddr(host string, port int) string {
+	if host == "" {
+		host = defaultHost
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// isLoopbackHost reports whether binding host only accepts connections from this machine
+func isLoopbackHost(host string) bool {
+	if host == "" || host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// checkExposure refuses to serve on a non-loopback address without access credentials, so that the files are
+// never exposed to the network unauthenticated. addr is the address the HTTP listener is bound to.
+func checkExposure(addr string, creds accessCredentials) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid listen address %s: %w", addr, err)
+	}
+	if isLoopbackHost(host) || !creds.empty() {
+		return nil
+	}
+
+	return fmt.Errorf(
+		"serving on %s exposes lum to the network, set an access token with --token or credentials with --auth",
+		host,
+	)
+}
+
+// networkURLs returns the URLs of the index page on every address of this machine that other devices can reach,
+// if the server is bound to the unspecified address host. Loopback and link-local addresses are left out.
+func networkURLs(host string, port int) []string {
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsUnspecified() {
+		return nil
+	}
+	ipv4Only := ip.To4() != nil
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+
+	var urls []string
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipv4Only && ipNet.IP.To4() == nil {
+			continue
+		}
+		urls = append(urls, fmt.Sprintf("http://%s/", net.JoinHostPort(ipNet.IP.String(), strconv.Itoa(port))))
+	}
+
+	return urls
+}
+
+// printNetworkURLs prints the URLs other devices can reach the server at serverURL on, bound to host
+func printNetworkURLs(w io.Writer, host, serverURL string) {
+	parsed, err := url.Parse(serverURL)
+	if err != nil {
+		return
+	}
+	port, err := strconv.Atoi(parsed.Port())
+	if err != nil {
+		return
+	}
+
+	for _, networkURL := range networkURLs(host, port) {
+		_, _ = fmt.Fprintln(w, networkURL)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBindAddr(t *testing.T) {
+	tests := []struct {
+		host     string
+		port     int
+		expected string
+	}{
+		{"", 6333, "127.0.0.1:6333"},
+		{"0.0.0.0", 8080, "0.0.0.0:8080"},
+		{"::", 6333, "[::]:6333"},
+		{normalizeHost("[::1]"), 0, "[::1]:0"},
+	}
+
+	for _, tt := range tests {
+		if got := bindAddr(tt.host, tt.port); got != tt.expected {
+			t.Errorf("bindAddr(%q, %d) = %q, expected %q", tt.host, tt.port, got, tt.expected)
+		}
+	}
+}
+
+func TestCheckExposure(t *testing.T) {
+	token := accessCredentials{token: "s3cret"}
+
+	for _, addr := range []string{"127.0.0.1:6333", "[::1]:6333", "localhost:6333", "127.0.0.2:6333"} {
+		if err := checkExposure(addr, accessCredentials{}); err != nil {
+			t.Errorf("Expected loopback address %s to be allowed without credentials: %v", addr, err)
+		}
+	}
+
+	for _, addr := range []string{"0.0.0.0:6333", "[::]:6333", "192.168.1.20:6333"} {
+		err := checkExposure(addr, accessCredentials{})
+		if err == nil || !strings.Contains(err.Error(), "--token") {
+			t.Errorf("Expected %s to require credentials, got %v", addr, err)
+		}
+		if err := checkExposure(addr, token); err != nil {
+			t.Errorf("Expected %s to be allowed with a token: %v", addr, err)
+		}
+	}
+
+	if err := checkExposure("nonsense", token); err == nil {
+		t.Error("Expected error for an invalid address")
+	}
+}
+
+func TestNetworkURLs(t *testing.T) {
+	for _, host := range []string{"127.0.0.1", "::1", "192.168.1.20", "localhost"} {
+		if urls := networkURLs(host, 6333); urls != nil {
+			t.Errorf("Expected no network URLs for %s, got %v", host, urls)
+		}
+	}
+
+	for _, url := range networkURLs("0.0.0.0", 6333) {
+		if !strings.HasPrefix(url, "http://") || !strings.HasSuffix(url, ":6333/") || strings.Contains(url, "[") {
+			t.Errorf("Expected an IPv4 URL on port 6333, got %s", url)
+		}
+		if strings.HasPrefix(url, "http://127.") {
+			t.Errorf("Expected loopback addresses to be left out, got %s", url)
+		}
+	}
+
+	var output bytes.Buffer
+	printNetworkURLs(&output, "127.0.0.1", "http://localhost:6333/")
+	if output.Len() != 0 {
+		t.Errorf("Expected nothing to be printed for a loopback host, got %q", output.String())
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// documents are what a lum invocation asks a server to serve
+type documents struct {
+	// paths are the files and directories to serve, filter selects the files tracked in directories
+	paths  []string
+	filter directoryFilter
+	// stdin is the content read from stdin, nil unless "-" was given
+	stdin []byte
+}
+
+// empty reports whether there is nothing to serve
+func (d documents) empty() bool {
+	return len(d.paths) == 0 && d.stdin == nil
+}
+
+// readDocuments turns the command line arguments into the documents to serve. The argument "-" reads a
+// document from stdin, the other arguments are expanded by expandPaths.
+func readDocuments(args []string, filter directoryFilter, stdin io.Reader) (documents, []addFileResult, error) {
+	docs := documents{filter: filter}
+
+	var pathArgs []string
+	for _, arg := range args {
+		if arg != stdinArg {
+			pathArgs = append(pathArgs, arg)
+			continue
+		}
+		if docs.stdin != nil {
+			continue
+		}
+
+		content, err := io.ReadAll(stdin)
+		if err != nil {
+			return docs, nil, fmt.Errorf("failed to read stdin: %w", err)
+		}
+		docs.stdin = append([]byte{}, content...)
+	}
+
+	var failures []addFileResult
+	docs.paths, failures = expandPaths(pathArgs)
+	return docs, failures, nil
+}
+
+// hasGlobMeta reports whether path contains characters that filepath.Match treats as a pattern
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[\`)
+}
+
+// expandPaths turns the file arguments into absolute paths. Arguments containing glob characters are expanded
+// by lum itself, so that quoted patterns work the same in every shell. Arguments that match nothing are
+// returned as failures, so that the remaining files can still be added. Every path is returned only once.
+func expandPaths(args []string) ([]string, []addFileResult) {
+	var paths []string
+	var failures []addFileResult
+	seen := make(map[string]bool)
+
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	for _, arg := range args {
+		absPath, err := filepath.Abs(arg)
+		if err != nil {
+			failures = append(failures, pathFailure(arg, errCodeInvalidRequest, "invalid path: %v", err))
+			continue
+		}
+
+		// A file whose name contains glob characters is taken literally
+		if _, err := os.Stat(absPath); err == nil {
+			add(absPath)
+			continue
+		}
+		if !hasGlobMeta(arg) {
+			failures = append(failures, pathFailure(absPath, errCodeFileNotFound, "file does not exist"))
+			continue
+		}
+
+		matches, err := filepath.Glob(absPath)
+		if err != nil {
+			failures = append(failures, pathFailure(arg, errCodeInvalidRequest, "invalid pattern: %v", err))
+			continue
+		}
+
+		matched := false
+		for _, match := range matches {
+			// Directories matched by a pattern such as docs/* are skipped
+			if info, err := os.Stat(match); err != nil || info.IsDir() {
+				continue
+			}
+			matched = true
+			add(match)
+		}
+		if !matched {
+			failures = append(failures, pathFailure(arg, errCodeFileNotFound, "no files match %s", arg))
+		}
+	}
+
+	return paths, failures
+}
+
+// pathFailure describes a file argument that cannot be added
+func pathFailure(path, code, format string, args ...any) addFileResult {
+	return addFileResult{Path: path, Error: &controlError{Code: code, Message: fmt.Sprintf(format, args...)}}
+}
+
+// printAddResults writes the URL of every added file to stdout and the reason every other file failed to stderr.
+// Returns the number of files that failed.
+func printAddResults(stdout, stderr io.Writer, results []addFileResult) int {
+	failed := 0
+	for _, result := range results {
+		if result.Error != nil {
+			fmt.Fprintf(stderr, "Failed to add %s: %s\n", result.Path, result.Error.Message)
+			failed++
+			continue
+		}
+		fmt.Fprintln(stdout, result.URL)
+	}
+
+	return failed
+}
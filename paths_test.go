@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandPaths(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"a.md", "b.md", "notes.txt", "docs/c.md", "[draft].md"} {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("# Test"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("Pattern", func(t *testing.T) {
+		paths, failures := expandPaths([]string{filepath.Join(tmpDir, "*.md")})
+		if len(failures) != 0 {
+			t.Errorf("Expected no failures, got %+v", failures)
+		}
+		expected := []string{
+			filepath.Join(tmpDir, "[draft].md"), filepath.Join(tmpDir, "a.md"), filepath.Join(tmpDir, "b.md"),
+		}
+		if strings.Join(paths, ",") != strings.Join(expected, ",") {
+			t.Errorf("Expected %v, got %v", expected, paths)
+		}
+	})
+
+	t.Run("DirectoriesSkipped", func(t *testing.T) {
+		paths, _ := expandPaths([]string{filepath.Join(tmpDir, "*")})
+		for _, path := range paths {
+			if path == filepath.Join(tmpDir, "docs") {
+				t.Errorf("Expected directories to be skipped, got %v", paths)
+			}
+		}
+	})
+
+	t.Run("Deduplicated", func(t *testing.T) {
+		paths, _ := expandPaths([]string{filepath.Join(tmpDir, "a.md"), filepath.Join(tmpDir, "?.md")})
+		expected := []string{filepath.Join(tmpDir, "a.md"), filepath.Join(tmpDir, "b.md")}
+		if strings.Join(paths, ",") != strings.Join(expected, ",") {
+			t.Errorf("Expected %v, got %v", expected, paths)
+		}
+	})
+
+	t.Run("LiteralNameWithGlobCharacters", func(t *testing.T) {
+		paths, failures := expandPaths([]string{filepath.Join(tmpDir, "[draft].md")})
+		if len(failures) != 0 || len(paths) != 1 || paths[0] != filepath.Join(tmpDir, "[draft].md") {
+			t.Errorf("Expected the file to be taken literally, got %v, %+v", paths, failures)
+		}
+	})
+
+	t.Run("PartialFailures", func(t *testing.T) {
+		missing := filepath.Join(tmpDir, "missing.md")
+		noMatch := filepath.Join(tmpDir, "*.rst")
+		paths, failures := expandPaths([]string{missing, filepath.Join(tmpDir, "docs", "c.md"), noMatch})
+
+		if len(paths) != 1 || paths[0] != filepath.Join(tmpDir, "docs", "c.md") {
+			t.Errorf("Expected the existing file to be kept, got %v", paths)
+		}
+		if len(failures) != 2 {
+			t.Fatalf("Expected two failures, got %+v", failures)
+		}
+		if failures[0].Path != missing || failures[0].Error.Code != errCodeFileNotFound {
+			t.Errorf("Expected missing file to fail, got %+v", failures[0])
+		}
+		if failures[1].Path != noMatch || !strings.Contains(failures[1].Error.Message, "no files match") {
+			t.Errorf("Expected pattern without matches to fail, got %+v", failures[1])
+		}
+	})
+}
+
+func TestReadDocuments(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "a.md")
+	if err := os.WriteFile(file, []byte("# Test"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	stdin := strings.NewReader("# From Stdin")
+	docs, failures, err := readDocuments([]string{file, stdinArg, stdinArg}, directoryFilter{}, stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 0 || len(docs.paths) != 1 || docs.paths[0] != file {
+		t.Errorf("Expected the file to be kept, got %v, %+v", docs.paths, failures)
+	}
+	if string(docs.stdin) != "# From Stdin" {
+		t.Errorf("Expected stdin to be read once, got %q", docs.stdin)
+	}
+
+	docs, _, err = readDocuments([]string{file}, directoryFilter{}, strings.NewReader("unused"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if docs.stdin != nil {
+		t.Errorf("Expected stdin not to be read without %q, got %q", stdinArg, docs.stdin)
+	}
+
+	docs, _, err = readDocuments([]string{stdinArg}, directoryFilter{}, strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if docs.stdin == nil || docs.empty() {
+		t.Error("Expected empty input from stdin to still be a document")
+	}
+}
+
+func TestPrintAddResults(t *testing.T) {
+	var stdout, stderr strings.Builder
+	failed := printAddResults(&stdout, &stderr, []addFileResult{
+		{Path: "/tmp/a.md", URL: "http://localhost:6333/?file=/tmp/a.md"},
+		{Path: "/tmp/b.md", Error: &controlError{Code: errCodeFileNotFound, Message: "file does not exist: /tmp/b.md"}},
+	})
+
+	if failed != 1 {
+		t.Errorf("Expected 1 failure, got %d", failed)
+	}
+	if stdout.String() != "http://localhost:6333/?file=/tmp/a.md\n" {
+		t.Errorf("Expected the URL of the added file, got %q", stdout.String())
+	}
+	if stderr.String() != "Failed to add /tmp/b.md: file does not exist: /tmp/b.md\n" {
+		t.Errorf("Expected the failed file to be reported, got %q", stderr.String())
+	}
+}
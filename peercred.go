@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// The control socket accepts commands that stop the daemon and read any file the user can read, so
+// both its directory and every connection to it are checked to belong to the current user.
+
+// checkPrivateDir verifies that dir is a real directory owned by the current user and inaccessible to
+// anyone else
+func checkPrivateDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is owned by UID %d, not by the current user (UID %d)", dir, stat.Uid, os.Getuid())
+	}
+	if perm := info.Mode().Perm(); perm != 0o700 {
+		return fmt.Errorf("%s has permissions %04o, expected 0700", dir, perm)
+	}
+
+	return nil
+}
+
+// checkPeer verifies that the process on the other end of a control connection runs as the current user
+func checkPeer(conn net.Conn) error {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return fmt.Errorf("not a Unix domain socket connection")
+	}
+
+	uid, err := peerUID(unixConn)
+	if err != nil {
+		return fmt.Errorf("failed to get peer credentials: %w", err)
+	}
+	if uid != os.Getuid() {
+		return fmt.Errorf("peer runs as UID %d, expected UID %d", uid, os.Getuid())
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckPrivateDir(t *testing.T) {
+	t.Run("PrivateDirectory", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "private")
+		if err := os.Mkdir(dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := checkPrivateDir(dir); err != nil {
+			t.Errorf("Expected private directory to be accepted, got %v", err)
+		}
+	})
+
+	t.Run("GroupAccessible", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "shared")
+		if err := os.Mkdir(dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(dir, 0o750); err != nil {
+			t.Fatal(err)
+		}
+
+		err := checkPrivateDir(dir)
+		if err == nil || !strings.Contains(err.Error(), "0750") {
+			t.Errorf("Expected permissions error, got %v", err)
+		}
+	})
+
+	t.Run("Symlink", func(t *testing.T) {
+		target := filepath.Join(t.TempDir(), "target")
+		if err := os.Mkdir(target, 0o700); err != nil {
+			t.Fatal(err)
+		}
+		link := filepath.Join(t.TempDir(), "link")
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+
+		err := checkPrivateDir(link)
+		if err == nil || !strings.Contains(err.Error(), "not a directory") {
+			t.Errorf("Expected symlink to be refused, got %v", err)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		if err := checkPrivateDir(filepath.Join(t.TempDir(), "missing")); err == nil {
+			t.Error("Expected error for missing directory")
+		}
+	})
+}
+
+func TestGetRuntimeDirRefusesInsecureDir(t *testing.T) {
+	oldXDG := os.Getenv("XDG_RUNTIME_DIR")
+	tmpDir := t.TempDir()
+	if err := os.Setenv("XDG_RUNTIME_DIR", tmpDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := os.Setenv("XDG_RUNTIME_DIR", oldXDG); err != nil {
+			t.Logf("Failed to restore XDG_RUNTIME_DIR: %v", err)
+		}
+	})
+
+	if err := os.Mkdir(filepath.Join(tmpDir, "lum"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(tmpDir, "lum"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := getRuntimeDir()
+	if err == nil || !strings.Contains(err.Error(), "insecure runtime directory") {
+		t.Errorf("Expected insecure runtime directory error, got %v", err)
+	}
+
+	if _, err := getSocketPath(); err == nil {
+		t.Error("Expected getSocketPath to fail for insecure runtime directory")
+	}
+}
+
+func TestCheckPeer(t *testing.T) {
+	t.Run("SameUser", func(t *testing.T) {
+		socketPath := filepath.Join(t.TempDir(), "peer.sock")
+		listener, err := net.Listen("unix", socketPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = listener.Close() }()
+
+		client, err := net.Dial("unix", socketPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = client.Close() }()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = conn.Close() }()
+
+		uid, err := peerUID(conn.(*net.UnixConn))
+		if err != nil {
+			t.Fatalf("Failed to get peer UID: %v", err)
+		}
+		if uid != os.Getuid() {
+			t.Errorf("Expected peer UID %d, got %d", os.Getuid(), uid)
+		}
+
+		if err := checkPeer(conn); err != nil {
+			t.Errorf("Expected connection from the same user to be accepted, got %v", err)
+		}
+	})
+
+	t.Run("NotUnixSocket", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close() }()
+		defer func() { _ = client.Close() }()
+
+		if err := checkPeer(server); err == nil {
+			t.Error("Expected non-Unix connection to be rejected")
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Every server writes its PID to lum.pid next to its control socket. A daemon that was killed leaves both
+// files behind; the PID file tells such a stale socket apart from one whose server is merely busy, and lets
+// 'lum --stop' signal a daemon that no longer answers on its socket. It records the server's start time along
+// with its PID, so that a process that was given the same PID after a crash is never mistaken for the server.
+
+// killTimeout bounds how long 'lum --stop --force' waits for the daemon to exit after SIGKILL
+const killTimeout = 2 * time.Second
+
+// errNoServer is returned when no server is listening on the control socket
+var errNoServer = errors.New("no existing server")
+
+// pidPathFor returns the path of the PID file belonging to the control socket at socketPath
+func pidPathFor(socketPath string) string {
+	return filepath.Join(filepath.Dir(socketPath), "lum.pid")
+}
+
+// writePIDFile records the PID and start time of this process next to the control socket at socketPath
+func writePIDFile(socketPath string) error {
+	record := strconv.Itoa(os.Getpid())
+	if start, err := processStartTime(os.Getpid()); err == nil {
+		record += " " + start
+	}
+	return os.WriteFile(pidPathFor(socketPath), []byte(record+"\n"), 0o600)
+}
+
+// readPIDFile returns the PID recorded next to the control socket at socketPath
+func readPIDFile(socketPath string) (int, error) {
+	pid, _, err := readPIDRecord(socketPath)
+	return pid, err
+}
+
+// readPIDRecord returns the PID and start time recorded next to the control socket at socketPath. The start time
+// is empty if it could not be recorded or the PID file was written by an older version.
+func readPIDRecord(socketPath string) (int, string, error) {
+	data, err := os.ReadFile(pidPathFor(socketPath))
+	if err != nil {
+		return 0, "", err
+	}
+
+	value, start, _ := strings.Cut(strings.TrimSpace(string(data)), " ")
+	pid, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid PID file %s: %w", pidPathFor(socketPath), err)
+	}
+
+	return pid, start, nil
+}
+
+// recordedServer returns the PID of the server that wrote the PID file next to the control socket at socketPath,
+// if that server is still running. A PID that cannot be confirmed to still belong to it is not reported, so that
+// it is never signalled.
+func recordedServer(socketPath string) (int, bool) {
+	pid, start, err := readPIDRecord(socketPath)
+	if err != nil || start == "" || !processExists(pid) {
+		return 0, false
+	}
+	return pid, sameProcess(pid, start)
+}
+
+// sameProcess reports whether the process with the given PID is still the one that started at start
+func sameProcess(pid int, start string) bool {
+	current, err := processStartTime(pid)
+	return err == nil && current == start
+}
+
+// removePIDFile removes the PID file next to the control socket at socketPath
+func removePIDFile(socketPath string) {
+	if err := os.Remove(pidPathFor(socketPath)); err != nil && !os.IsNotExist(err) {
+		slog.Error("Failed to remove PID file", "error", err)
+	}
+}
+
+// dialSocket connects to the control socket. A socket left behind by a server that is no longer running is
+// removed, and reported as errNoServer just like a missing socket.
+func dialSocket(socketPath string) (net.Conn, error) {
+	conn, err := net.Dial("unix", socketPath)
+	if err == nil {
+		return conn, nil
+	}
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w (socket does not exist)", errNoServer)
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) && removeStaleSocket(socketPath) {
+		return nil, fmt.Errorf("%w (removed stale socket)", errNoServer)
+	}
+
+	return nil, fmt.Errorf("failed to connect to existing server: %w", err)
+}
+
+// removeStaleSocket removes the control socket at socketPath and its PID file if the server that created them
+// is no longer running. Returns whether the socket was stale.
+func removeStaleSocket(socketPath string) bool {
+	// Unless the PID file confirms that its server is still running, nobody listening means the socket is stale
+	if _, running := recordedServer(socketPath); running {
+		return false
+	}
+
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		slog.Error("Failed to remove stale socket", "error", err)
+		return false
+	}
+	removePIDFile(socketPath)
+
+	return true
+}
+
+// stopByPID stops the daemon recorded in the PID file when it cannot be reached over its control socket.
+// dialErr is the error that prevented talking to it.
+func stopByPID(socketPath string, dialErr error, force bool) error {
+	pid, running := recordedServer(socketPath)
+	if !running {
+		if errors.Is(dialErr, errNoServer) {
+			return fmt.Errorf("no daemon running")
+		}
+		return fmt.Errorf("failed to connect to daemon: %w", dialErr)
+	}
+
+	fmt.Fprintf(os.Stderr, "Daemon (PID %d) is not responding on its control socket, sending SIGTERM\n", pid)
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		return fmt.Errorf("failed to signal daemon (PID %d): %w", pid, err)
+	}
+
+	return waitForStop(socketPath, pid, stopTimeout, force)
+}
+
+// waitForStop waits up to timeout for the daemon with the given PID to exit after it was asked to stop. With force,
+// a daemon that does not exit in time is killed and its control socket and PID file are removed.
+func waitForStop(socketPath string, pid int, timeout time.Duration, force bool) error {
+	start, startErr := processStartTime(pid)
+	err := waitForExit(pid, timeout)
+	if err == nil || !force {
+		return err
+	}
+
+	// The daemon may have exited and its PID been reused while waiting
+	if startErr != nil || !sameProcess(pid, start) {
+		return fmt.Errorf("PID %d no longer belongs to the daemon, not killing it", pid)
+	}
+
+	fmt.Fprintf(os.Stderr, "Daemon (PID %d) did not exit within %s, sending SIGKILL\n", pid, timeout)
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return fmt.Errorf("failed to kill daemon (PID %d): %w", pid, err)
+	}
+	if err := waitForExit(pid, killTimeout); err != nil {
+		return err
+	}
+
+	// A killed daemon cannot clean up after itself
+	if recorded, err := readPIDFile(socketPath); err == nil && recorded == pid {
+		if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove socket: %w", err)
+		}
+		removePIDFile(socketPath)
+	}
+
+	return nil
+}
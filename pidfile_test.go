@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// deadPID returns the PID of a process that has already exited
+func deadPID(t *testing.T) int {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+// leaveSocket creates a control socket file that nobody listens on, like one left behind by a killed daemon
+func leaveSocket(t *testing.T, socketPath string) {
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := listener.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writePID records pid and the start time of its process in the PID file next to socketPath, as its server would
+func writePID(t *testing.T, socketPath string, pid int) {
+	start, _ := processStartTime(pid)
+	writePIDRecord(t, socketPath, strconv.Itoa(pid)+" "+start)
+}
+
+// writePIDRecord writes record to the PID file next to socketPath
+func writePIDRecord(t *testing.T, socketPath, record string) {
+	if err := os.WriteFile(pidPathFor(socketPath), []byte(record), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPIDFile(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "control.sock")
+
+	if _, err := readPIDFile(socketPath); err == nil {
+		t.Error("Expected error reading missing PID file")
+	}
+
+	if err := writePIDFile(socketPath); err != nil {
+		t.Fatalf("Failed to write PID file: %v", err)
+	}
+	pid, err := readPIDFile(socketPath)
+	if err != nil {
+		t.Fatalf("Failed to read PID file: %v", err)
+	}
+	if pid != os.Getpid() {
+		t.Errorf("Expected PID %d, got %d", os.Getpid(), pid)
+	}
+	if running, ok := recordedServer(socketPath); !ok || running != os.Getpid() {
+		t.Errorf("Expected the PID file to confirm the running server, got %d", running)
+	}
+
+	removePIDFile(socketPath)
+	if _, err := os.Stat(pidPathFor(socketPath)); !os.IsNotExist(err) {
+		t.Error("Expected PID file to be removed")
+	}
+}
+
+func TestDialSocket(t *testing.T) {
+	t.Run("MissingSocket", func(t *testing.T) {
+		_, err := dialSocket(filepath.Join(t.TempDir(), "control.sock"))
+		if !errors.Is(err, errNoServer) {
+			t.Errorf("Expected errNoServer, got %v", err)
+		}
+	})
+
+	t.Run("StaleSocket", func(t *testing.T) {
+		socketPath := filepath.Join(t.TempDir(), "control.sock")
+		leaveSocket(t, socketPath)
+		writePID(t, socketPath, deadPID(t))
+
+		_, err := dialSocket(socketPath)
+		if !errors.Is(err, errNoServer) {
+			t.Errorf("Expected errNoServer, got %v", err)
+		}
+		if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+			t.Error("Expected stale socket to be removed")
+		}
+		if _, err := os.Stat(pidPathFor(socketPath)); !os.IsNotExist(err) {
+			t.Error("Expected stale PID file to be removed")
+		}
+	})
+
+	t.Run("StaleSocketWithoutPIDFile", func(t *testing.T) {
+		socketPath := filepath.Join(t.TempDir(), "control.sock")
+		leaveSocket(t, socketPath)
+
+		if _, err := dialSocket(socketPath); !errors.Is(err, errNoServer) {
+			t.Errorf("Expected errNoServer, got %v", err)
+		}
+		if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+			t.Error("Expected stale socket to be removed")
+		}
+	})
+
+	t.Run("ReusedPID", func(t *testing.T) {
+		// The PID now belongs to an unrelated process that started later than the recorded server
+		for _, record := range []string{strconv.Itoa(os.Getpid()) + " 1", strconv.Itoa(os.Getpid())} {
+			socketPath := filepath.Join(t.TempDir(), "control.sock")
+			leaveSocket(t, socketPath)
+			writePIDRecord(t, socketPath, record)
+
+			if _, err := dialSocket(socketPath); !errors.Is(err, errNoServer) {
+				t.Errorf("Expected errNoServer for PID file %q, got %v", record, err)
+			}
+			if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+				t.Errorf("Expected socket to be treated as stale for PID file %q", record)
+			}
+		}
+	})
+
+	t.Run("ServerStillRunning", func(t *testing.T) {
+		socketPath := filepath.Join(t.TempDir(), "control.sock")
+		leaveSocket(t, socketPath)
+		writePID(t, socketPath, os.Getpid())
+
+		_, err := dialSocket(socketPath)
+		if err == nil || errors.Is(err, errNoServer) {
+			t.Errorf("Expected connection error, got %v", err)
+		}
+		if _, err := os.Stat(socketPath); err != nil {
+			t.Error("Expected socket of a running server to be kept")
+		}
+	})
+}
+
+func TestWaitForStop(t *testing.T) {
+	t.Run("WithoutForce", func(t *testing.T) {
+		cmd := exec.Command("sleep", "60")
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = cmd.Process.Kill(); _ = cmd.Wait() })
+
+		socketPath := filepath.Join(t.TempDir(), "control.sock")
+		if err := waitForStop(socketPath, cmd.Process.Pid, 100*time.Millisecond, false); err == nil {
+			t.Error("Expected error when the process does not exit")
+		}
+		if !processExists(cmd.Process.Pid) {
+			t.Error("Process should not be killed without force")
+		}
+	})
+
+	t.Run("Force", func(t *testing.T) {
+		cmd := exec.Command("sleep", "60")
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = cmd.Wait() })
+
+		socketPath := filepath.Join(t.TempDir(), "control.sock")
+		leaveSocket(t, socketPath)
+		writePID(t, socketPath, cmd.Process.Pid)
+
+		if err := waitForStop(socketPath, cmd.Process.Pid, 100*time.Millisecond, true); err != nil {
+			t.Fatalf("Expected process to be killed, got %v", err)
+		}
+		if processExists(cmd.Process.Pid) {
+			t.Error("Expected process to be killed")
+		}
+		if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+			t.Error("Expected socket of the killed daemon to be removed")
+		}
+		if _, err := os.Stat(pidPathFor(socketPath)); !os.IsNotExist(err) {
+			t.Error("Expected PID file of the killed daemon to be removed")
+		}
+	})
+}
+
+func TestStopDaemonFallsBackToPID(t *testing.T) {
+	oldXDG := os.Getenv("XDG_RUNTIME_DIR")
+	if err := os.Setenv("XDG_RUNTIME_DIR", t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := os.Setenv("XDG_RUNTIME_DIR", oldXDG); err != nil {
+			t.Logf("Failed to restore XDG_RUNTIME_DIR: %v", err)
+		}
+	})
+
+	socketPath, err := getSocketPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A process that is alive but has no control socket stands in for an unresponsive daemon
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = cmd.Process.Kill(); _ = cmd.Wait() })
+	writePID(t, socketPath, cmd.Process.Pid)
+
+	if err := stopDaemon(false); err != nil {
+		t.Fatalf("Expected daemon to be stopped via its PID, got %v", err)
+	}
+	if processExists(cmd.Process.Pid) {
+		t.Error("Expected process to be terminated")
+	}
+}
+
+func TestStopDaemonIgnoresReusedPID(t *testing.T) {
+	oldXDG := os.Getenv("XDG_RUNTIME_DIR")
+	if err := os.Setenv("XDG_RUNTIME_DIR", t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := os.Setenv("XDG_RUNTIME_DIR", oldXDG); err != nil {
+			t.Logf("Failed to restore XDG_RUNTIME_DIR: %v", err)
+		}
+	})
+
+	socketPath, err := getSocketPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An unrelated process that was given the PID of a crashed daemon
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = cmd.Process.Kill(); _ = cmd.Wait() })
+	writePIDRecord(t, socketPath, strconv.Itoa(cmd.Process.Pid)+" 1")
+
+	if err := stopDaemon(true); err == nil {
+		t.Error("Expected no daemon to be found")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if !processExists(cmd.Process.Pid) {
+		t.Error("Expected the unrelated process to be left alone")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// processExists reports whether a process with the given PID is still running
+func processExists(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	// Signal 0 performs the existence and permission checks without sending anything
+	err := syscall.Kill(pid, 0)
+	if err != nil && !errors.Is(err, syscall.EPERM) {
+		return false
+	}
+
+	// A zombie has exited but still answers signals until its parent reaps it
+	if data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid)); err == nil {
+		if _, rest, found := strings.Cut(string(data), ") "); found && strings.HasPrefix(rest, "Z") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// waitForExit polls until the process with the given PID has exited or the timeout elapses
+func waitForExit(pid int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for processExists(pid) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("daemon (PID %d) did not exit within %s", pid, timeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return nil
+}
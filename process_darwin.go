@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// processStartTime returns when the process with the given PID started, using the kern.proc.pid sysctl.
+// Together with the PID it identifies a process, since PIDs are reused.
+func processStartTime(pid int) (string, error) {
+	info, err := unix.SysctlKinfoProc("kern.proc.pid", pid)
+	if err != nil {
+		return "", err
+	}
+	if int(info.Proc.P_pid) != pid {
+		return "", fmt.Errorf("no process with PID %d", pid)
+	}
+
+	start := info.Proc.P_starttime
+	return fmt.Sprintf("%d.%06d", start.Sec, start.Usec), nil
+}
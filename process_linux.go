@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// processStartTime returns when the process with the given PID started, in clock ticks since boot as recorded
+// in /proc. Together with the PID it identifies a process, since PIDs are reused.
+func processStartTime(pid int) (string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return "", err
+	}
+
+	// The command name may contain spaces, the fields after it do not. starttime is the 22nd field, the 20th
+	// after the command name.
+	_, rest, found := strings.Cut(string(data), ") ")
+	fields := strings.Fields(rest)
+	if !found || len(fields) < 20 {
+		return "", fmt.Errorf("unexpected format of /proc/%d/stat", pid)
+	}
+
+	return fields[19], nil
+}
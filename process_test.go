@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestProcessExists(t *testing.T) {
+	t.Run("CurrentProcess", func(t *testing.T) {
+		if !processExists(os.Getpid()) {
+			t.Error("Expected current process to exist")
+		}
+	})
+
+	t.Run("InvalidPID", func(t *testing.T) {
+		if processExists(0) || processExists(-1) {
+			t.Error("Expected invalid PIDs to be reported as not running")
+		}
+	})
+
+	t.Run("ExitedProcess", func(t *testing.T) {
+		cmd := exec.Command("true")
+		if err := cmd.Run(); err != nil {
+			t.Skipf("Cannot run helper process: %v", err)
+		}
+		if processExists(cmd.Process.Pid) {
+			t.Error("Expected exited process to be reported as not running")
+		}
+	})
+}
+
+func TestProcessStartTime(t *testing.T) {
+	start, err := processStartTime(os.Getpid())
+	if err != nil {
+		t.Fatalf("Failed to get start time of the current process: %v", err)
+	}
+	if again, err := processStartTime(os.Getpid()); err != nil || again != start {
+		t.Errorf("Expected a stable start time %q, got %q (%v)", start, again, err)
+	}
+	if !sameProcess(os.Getpid(), start) || sameProcess(os.Getpid(), start+"0") {
+		t.Error("Expected only the recorded start time to identify the current process")
+	}
+
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("Cannot run helper process: %v", err)
+	}
+	if _, err := processStartTime(cmd.Process.Pid); err == nil {
+		t.Error("Expected error for an exited process")
+	}
+}
+
+func TestWaitForExit(t *testing.T) {
+	t.Run("Exits", func(t *testing.T) {
+		cmd := exec.Command("sleep", "0.2")
+		if err := cmd.Start(); err != nil {
+			t.Skipf("Cannot start helper process: %v", err)
+		}
+		// Reap the child so it does not linger as a zombie
+		go func() { _ = cmd.Wait() }()
+
+		if err := waitForExit(cmd.Process.Pid, 5*time.Second); err != nil {
+			t.Errorf("Expected process to exit, got: %v", err)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		if err := waitForExit(os.Getpid(), 100*time.Millisecond); err == nil {
+			t.Error("Expected timeout waiting for the current process")
+		}
+	})
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// The JSON control protocol is newline-delimited JSON over the control socket. A client opens a
+// connection, sends a "hello" request carrying its protocol version, and may then send any number
+// of requests on the same connection. Every request carries an ID that is echoed in its response:
+//
+//	-> {"id":1,"method":"hello","params":{"protocol":1,"version":"1.2.3"}}
+//	<- {"id":1,"result":{"protocol":1,"version":"1.2.3"}}
+//	-> {"id":2,"method":"add","params":{"path":"/abs/file.md"}}
+//	<- {"id":2,"result":{"url":"http://localhost:6333/?file=/abs/file.md"}}
+//	-> {"id":3,"method":"remove","params":{"path":"/abs/missing.md"}}
+//	<- {"id":3,"error":{"code":"not_tracked","message":"file not tracked: /abs/missing.md"}}
+//
+// Connections whose first line is not a JSON object are handled by the legacy text protocol
+// (see handleControlCommand).
+
+const (
+	// protocolVersion is the newest control protocol version this build speaks
+	protocolVersion = 1
+	// minProtocolVersion is the oldest control protocol version this build still accepts
+	minProtocolVersion = 1
+	// handshakeTimeout bounds how long a client waits for the server to answer its hello
+	handshakeTimeout = 5 * time.Second
+)
+
+// Error codes returned in controlError.Code
+const (
+	errCodeInvalidRequest    = "invalid_request"
+	errCodeUnknownMethod     = "unknown_method"
+	errCodeHandshakeRequired = "handshake_required"
+	errCodeProtocolMismatch  = "protocol_mismatch"
+	errCodeFileNotFound      = "file_not_found"
+	errCodeNotTracked        = "not_tracked"
+	errCodeNotPromotable     = "not_promotable"
+	errCodeNotRestartable    = "not_restartable"
+	errCodeInternal          = "internal"
+)
+
+var (
+	// errLegacyServer is returned by dialControl when the server only speaks the legacy text protocol
+	errLegacyServer = errors.New("server does not support the JSON control protocol")
+
+	// errIncompatibleServer is returned by dialControl when the server speaks no protocol version this build supports
+	errIncompatibleServer = errors.New("incompatible server")
+)
+
+var (
+	// versionWarnings receives a warning when a server runs a different lum version than this one.
+	// Nil disables the warning.
+	versionWarnings io.Writer = os.Stderr
+	versionWarned   bool
+)
+
+// controlRequest is a single request sent by a client
+type controlRequest struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// controlResponse is the server's answer to a controlRequest with the same ID.
+// Exactly one of Result and Error is set.
+type controlResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *controlError   `json:"error,omitempty"`
+}
+
+// controlError is a typed error reported by the server
+type controlError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error implements the error interface
+func (e *controlError) Error() string {
+	return e.Message
+}
+
+// helloMessage is exchanged during the handshake. The client sends the newest protocol version it
+// speaks, and the server replies with the version both sides will use.
+type helloMessage struct {
+	Protocol int    `json:"protocol"`
+	Version  string `json:"version"`
+}
+
+// pathParams are the parameters of requests that operate on a single file
+type pathParams struct {
+	Path string `json:"path"`
+}
+
+// addResult is the result of the "add" method
+type addResult struct {
+	URL string `json:"url"`
+}
+
+// pathsParams are the parameters of requests that operate on several files. The filter selects the files
+// tracked in directories.
+type pathsParams struct {
+	Paths []string `json:"paths"`
+	directoryFilter
+}
+
+// addFilesResult is the result of the "add_files" method, with one entry per requested path in request order
+type addFilesResult struct {
+	Files []addFileResult `json:"files"`
+}
+
+// addFileResult tells whether a single file of an "add_files" request was added. Exactly one of URL and
+// Error is set.
+type addFileResult struct {
+	Path  string        `json:"path"`
+	URL   string        `json:"url,omitempty"`
+	Error *controlError `json:"error,omitempty"`
+}
+
+// stopResult is the result of the "stop" method. The PID lets the client wait for the
+// server process to exit.
+type stopResult struct {
+	PID int `json:"pid"`
+}
+
+// handOverResult is the result of the "promote" and "restart" methods. URL is where the new daemon serves.
+type handOverResult struct {
+	URL string `json:"url"`
+}
+
+// listResult is the result of the "list" method
+type listResult struct {
+	Files []trackedFileInfo `json:"files"`
+}
+
+// serveProtocolSession handles a JSON protocol connection until the client disconnects.
+// firstLine is the line already consumed by handleControlCommand to detect the protocol.
+func serveProtocolSession(conn net.Conn, reader *bufio.Reader, firstLine string) {
+	encoder := json.NewEncoder(conn)
+	negotiated := 0
+
+	line := firstLine
+	for {
+		var req controlRequest
+		var resp controlResponse
+
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			resp.Error = &controlError{Code: errCodeInvalidRequest, Message: fmt.Sprintf("invalid request: %v", err)}
+		} else {
+			resp.ID = req.ID
+			var result any
+			var cerr *controlError
+
+			switch {
+			case req.Method == "hello":
+				var hello helloMessage
+				hello, cerr = negotiateProtocol(req.Params)
+				if cerr == nil {
+					negotiated = hello.Protocol
+					result = hello
+				}
+			case negotiated == 0:
+				cerr = &controlError{
+					Code:    errCodeHandshakeRequired,
+					Message: "handshake required: send 'hello' before any other request",
+				}
+			default:
+				result, cerr = dispatchControlRequest(&req)
+			}
+
+			if cerr != nil {
+				resp.Error = cerr
+			} else if data, err := json.Marshal(result); err != nil {
+				resp.Error = &controlError{
+					Code:    errCodeInternal,
+					Message: fmt.Sprintf("failed to encode result: %v", err),
+				}
+			} else {
+				resp.Result = data
+			}
+		}
+
+		if err := encoder.Encode(resp); err != nil {
+			slog.Error("Failed to write control response", "error", err)
+			return
+		}
+
+		if req.Method == "stop" && resp.Error == nil {
+			stopServer()
+		}
+		if (req.Method == "promote" || req.Method == "restart") && resp.Error == nil {
+			slog.Info("Handed over to the new daemon, shutting down")
+			requestShutdown()
+		}
+
+		var err error
+		line, err = reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		recordActivity()
+	}
+}
+
+// negotiateProtocol picks the protocol version to use with a client from its hello parameters
+func negotiateProtocol(params json.RawMessage) (helloMessage, *controlError) {
+	var hello helloMessage
+	if err := json.Unmarshal(params, &hello); err != nil {
+		return hello, &controlError{Code: errCodeInvalidRequest, Message: fmt.Sprintf("invalid hello: %v", err)}
+	}
+
+	negotiated := min(hello.Protocol, protocolVersion)
+	if negotiated < minProtocolVersion {
+		return hello, &controlError{
+			Code: errCodeProtocolMismatch,
+			Message: fmt.Sprintf(
+				"control protocol version %d is not supported (server speaks %d to %d)",
+				hello.Protocol, minProtocolVersion, protocolVersion,
+			),
+		}
+	}
+
+	return helloMessage{Protocol: negotiated, Version: Version}, nil
+}
+
+// dispatchControlRequest runs a single request after the handshake and returns its result
+func dispatchControlRequest(req *controlRequest) (any, *controlError) {
+	switch req.Method {
+	case "add":
+		params, cerr := decodePathParams(req.Params)
+		if cerr != nil {
+			return nil, cerr
+		}
+		url, cerr := addTrackedFile(params.Path, directoryFilter{})
+		if cerr != nil {
+			return nil, cerr
+		}
+		return addResult{URL: url}, nil
+
+	case "add_files":
+		var params pathsParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &controlError{Code: errCodeInvalidRequest, Message: fmt.Sprintf("invalid params: %v", err)}
+		}
+		if len(params.Paths) == 0 {
+			return nil, &controlError{Code: errCodeInvalidRequest, Message: "missing 'paths' parameter"}
+		}
+		return addFilesResult{Files: addTrackedFiles(params.Paths, params.directoryFilter)}, nil
+
+	case "add_stdin":
+		var params stdinParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &controlError{Code: errCodeInvalidRequest, Message: fmt.Sprintf("invalid params: %v", err)}
+		}
+		id, err := addStdinDocument([]byte(params.Content))
+		if err != nil {
+			return nil, &controlError{Code: errCodeInternal, Message: err.Error()}
+		}
+		return addResult{URL: fileURL(serverPort(), id)}, nil
+
+	case "push":
+		var params pushParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &controlError{Code: errCodeInvalidRequest, Message: fmt.Sprintf("invalid params: %v", err)}
+		}
+		if params.Path == "" {
+			return nil, &controlError{Code: errCodeInvalidRequest, Message: "missing 'path' parameter"}
+		}
+		if len(params.Content) > maxPushSize {
+			return nil, &controlError{
+				Code:    errCodeInvalidRequest,
+				Message: fmt.Sprintf("buffer too large: %d bytes (at most %d)", len(params.Content), maxPushSize),
+			}
+		}
+		url, cerr := pushBuffer(params.Path, []byte(params.Content))
+		if cerr != nil {
+			return nil, cerr
+		}
+		return addResult{URL: url}, nil
+
+	case "remove":
+		params, cerr := decodePathParams(req.Params)
+		if cerr != nil {
+			return nil, cerr
+		}
+		if cerr := removeTrackedFile(params.Path); cerr != nil {
+			return nil, cerr
+		}
+		return struct{}{}, nil
+
+	case "list":
+		return listResult{Files: listFiles(serverPort())}, nil
+
+	case "status":
+		return currentStatus(), nil
+
+	case "promote":
+		// The server shuts down after the response has been written
+		url, cerr := promoteToDaemon()
+		if cerr != nil {
+			return nil, cerr
+		}
+		return handOverResult{URL: url}, nil
+
+	case "restart":
+		// The server shuts down after the response has been written
+		url, cerr := restartDaemon()
+		if cerr != nil {
+			return nil, cerr
+		}
+		return handOverResult{URL: url}, nil
+
+	case "stop":
+		// The server shuts down after the response has been written
+		return stopResult{PID: os.Getpid()}, nil
+
+	default:
+		return nil, &controlError{Code: errCodeUnknownMethod, Message: fmt.Sprintf("unknown method: %s", req.Method)}
+	}
+}
+
+// decodePathParams decodes and validates the parameters of single-file requests
+func decodePathParams(raw json.RawMessage) (pathParams, *controlError) {
+	var params pathParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return params, &controlError{Code: errCodeInvalidRequest, Message: fmt.Sprintf("invalid params: %v", err)}
+	}
+	if params.Path == "" {
+		return params, &controlError{Code: errCodeInvalidRequest, Message: "missing 'path' parameter"}
+	}
+	return params, nil
+}
+
+// controlClient is a client connection speaking the JSON control protocol
+type controlClient struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	encoder  *json.Encoder
+	nextID   int
+	protocol int
+	version  string
+}
+
+// dialControl connects to the control socket of the selected instance and performs the protocol handshake.
+// Returns errLegacyServer (wrapped) if the server predates the JSON protocol.
+func dialControl() (*controlClient, error) {
+	socketPath, err := getSocketPath()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get socket path: %w", err)
+	}
+
+	return dialControlAt(socketPath)
+}
+
+// dialControlAt connects to the control socket at socketPath and performs the protocol handshake
+func dialControlAt(socketPath string) (*controlClient, error) {
+	conn, err := dialSocket(socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &controlClient{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		encoder: json.NewEncoder(conn),
+	}
+
+	// A server that accepts connections but never answers must not hang the client
+	if err := conn.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to set handshake deadline: %w", err)
+	}
+
+	var hello helloMessage
+	if err := client.call("hello", helloMessage{Protocol: protocolVersion, Version: Version}, &hello); err != nil {
+		_ = conn.Close()
+		var cerr *controlError
+		if errors.As(err, &cerr) && cerr.Code == errCodeProtocolMismatch {
+			return nil, fmt.Errorf("%w: %w (stop it with 'lum --stop' and start it again)", errIncompatibleServer, err)
+		}
+		return nil, err
+	}
+
+	if hello.Protocol < minProtocolVersion || hello.Protocol > protocolVersion {
+		_ = conn.Close()
+		return nil, fmt.Errorf(
+			"%w: it speaks control protocol version %d, this lum speaks %d to %d "+
+				"(stop it with 'lum --stop' and start it again)",
+			errIncompatibleServer, hello.Protocol, minProtocolVersion, protocolVersion,
+		)
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to clear handshake deadline: %w", err)
+	}
+
+	client.protocol = hello.Protocol
+	client.version = hello.Version
+	warnVersionMismatch(hello.Version)
+
+	return client, nil
+}
+
+// warnVersionMismatch warns once per process if the server runs a different lum version than this one
+func warnVersionMismatch(serverVersion string) {
+	if serverVersion == Version || versionWarnings == nil || versionWarned {
+		return
+	}
+	versionWarned = true
+
+	fmt.Fprintf(
+		versionWarnings,
+		"Warning: the running daemon is lum %s, this is lum %s. Run 'lum --restart' to switch it to this version.\n",
+		serverVersion, Version,
+	)
+}
+
+// call sends a request and decodes the result into result (which may be nil).
+// Errors reported by the server are returned as *controlError.
+func (c *controlClient) call(method string, params, result any) error {
+	c.nextID++
+	req := controlRequest{ID: c.nextID, Method: method}
+
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("failed to encode params: %w", err)
+		}
+		req.Params = data
+	}
+
+	if err := c.encoder.Encode(req); err != nil {
+		return fmt.Errorf("failed to send command: %w", err)
+	}
+
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	// Servers that only speak the legacy text protocol answer with an ERROR line
+	if strings.HasPrefix(line, "ERROR ") || strings.HasPrefix(line, "OK") {
+		return errLegacyServer
+	}
+
+	var resp controlResponse
+	if err := json.Unmarshal([]byte(line), &resp); err != nil {
+		return fmt.Errorf("unexpected response: %s", strings.TrimSpace(line))
+	}
+
+	if resp.ID != req.ID {
+		return fmt.Errorf("unexpected response ID: got %d, expected %d", resp.ID, req.ID)
+	}
+
+	if resp.Error != nil {
+		return resp.Error
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			return fmt.Errorf("failed to decode result: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Close closes the connection to the server
+func (c *controlClient) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNegotiateProtocol(t *testing.T) {
+	t.Run("SameVersion", func(t *testing.T) {
+		hello, cerr := negotiateProtocol(json.RawMessage(fmt.Sprintf(`{"protocol":%d}`, protocolVersion)))
+		if cerr != nil {
+			t.Fatalf("Unexpected error: %v", cerr)
+		}
+		if hello.Protocol != protocolVersion {
+			t.Errorf("Expected protocol %d, got %d", protocolVersion, hello.Protocol)
+		}
+		if hello.Version != Version {
+			t.Errorf("Expected version %s, got %s", Version, hello.Version)
+		}
+	})
+
+	t.Run("NewerClient", func(t *testing.T) {
+		hello, cerr := negotiateProtocol(json.RawMessage(`{"protocol":999}`))
+		if cerr != nil {
+			t.Fatalf("Unexpected error: %v", cerr)
+		}
+		if hello.Protocol != protocolVersion {
+			t.Errorf("Expected server to downgrade to %d, got %d", protocolVersion, hello.Protocol)
+		}
+	})
+
+	t.Run("TooOldClient", func(t *testing.T) {
+		_, cerr := negotiateProtocol(json.RawMessage(`{"protocol":0}`))
+		if cerr == nil {
+			t.Fatal("Expected error for unsupported protocol version")
+		}
+		if cerr.Code != errCodeProtocolMismatch {
+			t.Errorf("Expected code %s, got %s", errCodeProtocolMismatch, cerr.Code)
+		}
+	})
+
+	t.Run("InvalidParams", func(t *testing.T) {
+		_, cerr := negotiateProtocol(json.RawMessage(`"not an object"`))
+		if cerr == nil || cerr.Code != errCodeInvalidRequest {
+			t.Errorf("Expected %s error, got %v", errCodeInvalidRequest, cerr)
+		}
+	})
+}
+
+func TestProtocolSession(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.md")
+
+	if err := os.WriteFile(testFile, []byte("# Test"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	port := 16406
+
+	// Setup environment
+	tmpRuntimeDir := t.TempDir()
+	oldXDG := os.Getenv("XDG_RUNTIME_DIR")
+	if err := os.Setenv("XDG_RUNTIME_DIR", tmpRuntimeDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cleanupSocket()
+		if oldXDG != "" {
+			_ = os.Setenv("XDG_RUNTIME_DIR", oldXDG)
+		} else {
+			_ = os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+	})
+
+	// Control clients are given URLs on the port the server is bound to
+	serverAddr = fmt.Sprintf("127.0.0.1:%d", port)
+
+	if err := startControlSocket(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	socketPath, err := getSocketPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// roundTrip sends a raw request line and decodes the response
+	roundTrip := func(t *testing.T, conn net.Conn, reader *bufio.Reader, line string) controlResponse {
+		t.Helper()
+		if _, err := fmt.Fprintf(conn, "%s\n", line); err != nil {
+			t.Fatal(err)
+		}
+		response, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp controlResponse
+		if err := json.Unmarshal([]byte(response), &resp); err != nil {
+			t.Fatalf("Response is not valid JSON: %v\n%s", err, response)
+		}
+		return resp
+	}
+
+	t.Run("HandshakeRequired", func(t *testing.T) {
+		conn, err := net.Dial("unix", socketPath)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer func() { _ = conn.Close() }()
+
+		resp := roundTrip(t, conn, bufio.NewReader(conn), `{"id":7,"method":"list"}`)
+		if resp.ID != 7 {
+			t.Errorf("Expected ID 7, got %d", resp.ID)
+		}
+		if resp.Error == nil || resp.Error.Code != errCodeHandshakeRequired {
+			t.Errorf("Expected %s error, got %+v", errCodeHandshakeRequired, resp.Error)
+		}
+	})
+
+	t.Run("MultipleRequestsOnOneConnection", func(t *testing.T) {
+		conn, err := net.Dial("unix", socketPath)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer func() { _ = conn.Close() }()
+		reader := bufio.NewReader(conn)
+
+		resp := roundTrip(t, conn, reader, fmt.Sprintf(`{"id":1,"method":"hello","params":{"protocol":%d}}`,
+			protocolVersion))
+		if resp.Error != nil {
+			t.Fatalf("Handshake failed: %v", resp.Error)
+		}
+
+		resp = roundTrip(t, conn, reader, fmt.Sprintf(`{"id":2,"method":"add","params":{"path":%q}}`, testFile))
+		if resp.ID != 2 || resp.Error != nil {
+			t.Fatalf("Expected successful add with ID 2, got %+v", resp)
+		}
+		var added addResult
+		if err := json.Unmarshal(resp.Result, &added); err != nil {
+			t.Fatal(err)
+		}
+		expectedURL := fmt.Sprintf("http://localhost:%d/?file=%s", port, testFile)
+		if added.URL != expectedURL {
+			t.Errorf("Expected URL %s, got %s", expectedURL, added.URL)
+		}
+
+		resp = roundTrip(t, conn, reader, `{"id":3,"method":"list"}`)
+		var listed listResult
+		if err := json.Unmarshal(resp.Result, &listed); err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, info := range listed.Files {
+			if info.Path == testFile {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected %s in list, got %+v", testFile, listed.Files)
+		}
+
+		resp = roundTrip(t, conn, reader, fmt.Sprintf(`{"id":4,"method":"remove","params":{"path":%q}}`, testFile))
+		if resp.ID != 4 || resp.Error != nil {
+			t.Errorf("Expected successful remove with ID 4, got %+v", resp)
+		}
+
+		resp = roundTrip(t, conn, reader, fmt.Sprintf(`{"id":5,"method":"remove","params":{"path":%q}}`, testFile))
+		if resp.Error == nil || resp.Error.Code != errCodeNotTracked {
+			t.Errorf("Expected %s error, got %+v", errCodeNotTracked, resp.Error)
+		}
+	})
+
+	t.Run("AddFiles", func(t *testing.T) {
+		client, err := dialControl()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer closeControlClient(client)
+
+		var result addFilesResult
+		paths := pathsParams{Paths: []string{testFile, "/nonexistent/file.md"}}
+		if err := client.call("add_files", paths, &result); err != nil {
+			t.Fatalf("Expected add_files to succeed, got: %v", err)
+		}
+
+		if len(result.Files) != 2 {
+			t.Fatalf("Expected one result per path, got %+v", result.Files)
+		}
+		expectedURL := fmt.Sprintf("http://localhost:%d/?file=%s", port, testFile)
+		if result.Files[0].Path != testFile || result.Files[0].URL != expectedURL || result.Files[0].Error != nil {
+			t.Errorf("Expected %s to be added at %s, got %+v", testFile, expectedURL, result.Files[0])
+		}
+		if result.Files[1].Error == nil || result.Files[1].Error.Code != errCodeFileNotFound {
+			t.Errorf("Expected %s error for the missing file, got %+v", errCodeFileNotFound, result.Files[1])
+		}
+
+		if err := removeFile(testFile); err != nil {
+			t.Errorf("Expected the file to be tracked: %v", err)
+		}
+	})
+
+	t.Run("AddStdin", func(t *testing.T) {
+		url, err := addStdinToExistingServer([]byte("# From Stdin"))
+		if err != nil {
+			t.Fatalf("Expected add_stdin to succeed, got: %v", err)
+		}
+
+		prefix := fmt.Sprintf("http://localhost:%d/?file=", port)
+		id := strings.TrimPrefix(url, prefix)
+		if !strings.HasPrefix(url, prefix) || !isStdinID(id) {
+			t.Fatalf("Expected URL of a stdin document, got %s", url)
+		}
+		if err := removeFile(id); err != nil {
+			t.Errorf("Expected the document to be tracked: %v", err)
+		}
+	})
+
+	t.Run("TypedErrors", func(t *testing.T) {
+		conn, err := net.Dial("unix", socketPath)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer func() { _ = conn.Close() }()
+		reader := bufio.NewReader(conn)
+
+		roundTrip(t, conn, reader, fmt.Sprintf(`{"id":1,"method":"hello","params":{"protocol":%d}}`, protocolVersion))
+
+		tests := []struct {
+			line string
+			code string
+		}{
+			{`{"id":2,"method":"frobnicate"}`, errCodeUnknownMethod},
+			{`{"id":3,"method":"add","params":{}}`, errCodeInvalidRequest},
+			{`{"id":4,"method":"add","params":{"path":"/nonexistent/file.md"}}`, errCodeFileNotFound},
+			{`{"id":6,"method":"add_files","params":{"paths":[]}}`, errCodeInvalidRequest},
+			{`{"id":8,"method":"push","params":{"content":"# Buffer"}}`, errCodeInvalidRequest},
+			{`{"id":9,"method":"push","params":{"path":"/nonexistent/file.md","content":"#"}}`, errCodeNotTracked},
+			{`{"id":5,`, errCodeInvalidRequest},
+		}
+
+		for _, tt := range tests {
+			resp := roundTrip(t, conn, reader, tt.line)
+			if resp.Error == nil || resp.Error.Code != tt.code {
+				t.Errorf("%s: expected %s error, got %+v", tt.line, tt.code, resp.Error)
+			}
+		}
+	})
+
+	t.Run("Client", func(t *testing.T) {
+		client, err := dialControl()
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		defer func() { _ = client.Close() }()
+
+		if client.protocol != protocolVersion {
+			t.Errorf("Expected negotiated protocol %d, got %d", protocolVersion, client.protocol)
+		}
+		if client.version != Version {
+			t.Errorf("Expected server version %s, got %s", Version, client.version)
+		}
+
+		err = client.call("add", pathParams{Path: "/nonexistent/file.md"}, nil)
+		var cerr *controlError
+		if !errors.As(err, &cerr) || cerr.Code != errCodeFileNotFound {
+			t.Errorf("Expected %s error, got %v", errCodeFileNotFound, err)
+		}
+
+		oldMode := serverMode
+		serverMode = modeDaemon
+		err = client.call("promote", nil, nil)
+		serverMode = oldMode
+		if !errors.As(err, &cerr) || cerr.Code != errCodeNotPromotable {
+			t.Errorf("Expected %s error, got %v", errCodeNotPromotable, err)
+		}
+
+		serverMode = modeOneOff
+		err = client.call("restart", nil, nil)
+		serverMode = oldMode
+		if !errors.As(err, &cerr) || cerr.Code != errCodeNotRestartable {
+			t.Errorf("Expected %s error, got %v", errCodeNotRestartable, err)
+		}
+
+		oldOptions := daemonOptions
+		serverMode = modeDaemon
+		daemonOptions = &options{foreground: true}
+		err = client.call("restart", nil, nil)
+		serverMode = oldMode
+		daemonOptions = oldOptions
+		if !errors.As(err, &cerr) || !strings.Contains(cerr.Message, "supervisor") {
+			t.Errorf("Expected %s error for a daemon in the foreground, got %v", errCodeNotRestartable, err)
+		}
+
+		err = callExistingServer("frobnicate", nil, nil)
+		if err == nil || !strings.Contains(err.Error(), "lum --restart") {
+			t.Errorf("Expected unknown methods to suggest a restart, got %v", err)
+		}
+
+		var status daemonStatus
+		if err := client.call("status", nil, &status); err != nil {
+			t.Fatalf("Status failed: %v", err)
+		}
+		if status.PID != os.Getpid() {
+			t.Errorf("Expected PID %d, got %d", os.Getpid(), status.PID)
+		}
+	})
+}
+
+func TestLegacyServerFallback(t *testing.T) {
+	tmpRuntimeDir := t.TempDir()
+	oldXDG := os.Getenv("XDG_RUNTIME_DIR")
+	if err := os.Setenv("XDG_RUNTIME_DIR", tmpRuntimeDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if oldXDG != "" {
+			_ = os.Setenv("XDG_RUNTIME_DIR", oldXDG)
+		} else {
+			_ = os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+	})
+
+	socketPath, err := getSocketPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fake a server that only understands the legacy text protocol
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			if path, found := strings.CutPrefix(strings.TrimSpace(line), "ADD "); found {
+				_, _ = fmt.Fprintf(conn, "OK http://localhost:6333/?file=%s\n", path)
+			} else {
+				_, _ = fmt.Fprintf(conn, "ERROR invalid command: expected 'ADD <path>' or 'STOP'\n")
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	t.Run("DialReportsLegacyServer", func(t *testing.T) {
+		_, err := dialControl()
+		if !errors.Is(err, errLegacyServer) {
+			t.Errorf("Expected errLegacyServer, got %v", err)
+		}
+	})
+
+	t.Run("AddFallsBackToLegacyProtocol", func(t *testing.T) {
+		url, err := tryAddToExistingServer("/tmp/test.md")
+		if err != nil {
+			t.Fatalf("Expected fallback to succeed, got: %v", err)
+		}
+		if url != "http://localhost:6333/?file=/tmp/test.md" {
+			t.Errorf("Unexpected URL: %s", url)
+		}
+	})
+
+	t.Run("AddFilesFallsBackToLegacyProtocol", func(t *testing.T) {
+		results, err := addFilesToExistingServer([]string{"/tmp/a.md", "/tmp/b.md"}, directoryFilter{})
+		if err != nil {
+			t.Fatalf("Expected fallback to succeed, got: %v", err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("Expected one result per file, got %+v", results)
+		}
+		for _, result := range results {
+			if result.Error != nil || result.URL != "http://localhost:6333/?file="+result.Path {
+				t.Errorf("Unexpected result: %+v", result)
+			}
+		}
+	})
+}
+
+func TestWarnVersionMismatch(t *testing.T) {
+	oldWarnings, oldWarned := versionWarnings, versionWarned
+	t.Cleanup(func() {
+		versionWarnings, versionWarned = oldWarnings, oldWarned
+	})
+
+	var buf strings.Builder
+	versionWarnings = &buf
+	versionWarned = false
+
+	warnVersionMismatch(Version)
+	if buf.Len() != 0 {
+		t.Errorf("Expected no warning for the same version, got %q", buf.String())
+	}
+
+	warnVersionMismatch("0.0.1")
+	if !strings.Contains(buf.String(), "lum 0.0.1") || !strings.Contains(buf.String(), "lum --restart") {
+		t.Errorf("Expected version mismatch warning, got %q", buf.String())
+	}
+
+	// The warning is only printed once per process
+	buf.Reset()
+	warnVersionMismatch("0.0.2")
+	if buf.Len() != 0 {
+		t.Errorf("Expected a single warning, got %q", buf.String())
+	}
+}
+
+func TestIncompatibleServer(t *testing.T) {
+	tmpRuntimeDir := t.TempDir()
+	oldXDG := os.Getenv("XDG_RUNTIME_DIR")
+	if err := os.Setenv("XDG_RUNTIME_DIR", tmpRuntimeDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if oldXDG != "" {
+			_ = os.Setenv("XDG_RUNTIME_DIR", oldXDG)
+		} else {
+			_ = os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+	})
+
+	socketPath, err := getSocketPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fake a server that only speaks a future protocol version
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			reader := bufio.NewReader(conn)
+			line, _ := reader.ReadString('\n')
+			if strings.HasPrefix(line, "{") {
+				_, _ = fmt.Fprintf(conn, `{"id":1,"error":{"code":"protocol_mismatch","message":"too old"}}`+"\n")
+			} else {
+				_, _ = fmt.Fprintf(conn, "OK 1234\n")
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	_, err = dialControl()
+	if !errors.Is(err, errIncompatibleServer) {
+		t.Fatalf("Expected errIncompatibleServer, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "lum --stop") {
+		t.Errorf("Expected the error to explain how to recover, got %v", err)
+	}
+
+	var buf strings.Builder
+	printVersion(&buf)
+	if !strings.Contains(buf.String(), "daemon: incompatible server") {
+		t.Errorf("Expected incompatible daemon in version output, got:\n%s", buf.String())
+	}
+}
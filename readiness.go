@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The daemonized child reports whether it started successfully over a pipe inherited from the
+// parent. It writes a single line, "OK <url>" once it is serving or "ERROR <message>" if startup
+// failed, and the parent waits for that line before exiting.
+
+const (
+	// readyFDEnv holds the number of the inherited file descriptor the child reports readiness on
+	readyFDEnv = "LUM_READY_FD"
+	// readyTimeout bounds how long the parent waits for the child to report readiness
+	readyTimeout = 10 * time.Second
+)
+
+// readyPipe is the write end of the readiness pipe in the daemonized child, nil once it has been used
+var readyPipe *os.File
+
+// openReadyPipe picks up the readiness pipe passed by the parent process, if any
+func openReadyPipe() {
+	value := os.Getenv(readyFDEnv)
+	if value == "" {
+		return
+	}
+
+	// Processes started by the daemon must not inherit the variable
+	_ = os.Unsetenv(readyFDEnv)
+
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Error("Invalid readiness file descriptor", "variable", readyFDEnv, "value", value)
+		return
+	}
+
+	readyPipe = os.NewFile(uintptr(fd), "ready")
+}
+
+// reportReady tells the parent process that the daemon is serving at url
+func reportReady(url string) {
+	writeReadyLine("OK " + url)
+}
+
+// reportStartupError tells the parent process that the daemon failed to start
+func reportStartupError(err error) {
+	// The message must fit on a single line
+	writeReadyLine("ERROR " + strings.ReplaceAll(err.Error(), "\n", " "))
+}
+
+// writeReadyLine writes the readiness line and closes the pipe, so that only the first report is delivered
+func writeReadyLine(line string) {
+	if readyPipe == nil {
+		return
+	}
+
+	if _, err := fmt.Fprintln(readyPipe, line); err != nil {
+		slog.Error("Failed to report readiness", "error", err)
+	}
+	if err := readyPipe.Close(); err != nil {
+		slog.Error("Failed to close readiness pipe", "error", err)
+	}
+	readyPipe = nil
+}
+
+// waitForReady waits for the daemonized child to report on the readiness pipe and returns the URL it
+// is serving at. Startup errors reported by the child are returned as errors.
+func waitForReady(r io.Reader, timeout time.Duration) (string, error) {
+	lineChan := make(chan string, 1)
+	errChan := make(chan error, 1)
+	go func() {
+		line, err := bufio.NewReader(r).ReadString('\n')
+		if err != nil {
+			errChan <- err
+			return
+		}
+		lineChan <- strings.TrimSpace(line)
+	}()
+
+	var line string
+	select {
+	case line = <-lineChan:
+	case err := <-errChan:
+		if errors.Is(err, io.EOF) {
+			return "", errors.New("daemon exited before it was ready")
+		}
+		return "", fmt.Errorf("failed to read daemon readiness: %w", err)
+	case <-time.After(timeout):
+		return "", fmt.Errorf("daemon did not become ready within %s", timeout)
+	}
+
+	if url, found := strings.CutPrefix(line, "OK "); found {
+		return url, nil
+	}
+	if message, found := strings.CutPrefix(line, "ERROR "); found {
+		return "", errors.New(message)
+	}
+
+	return "", fmt.Errorf("unexpected readiness report: %s", line)
+}
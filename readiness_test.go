@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitForReady(t *testing.T) {
+	t.Run("Ready", func(t *testing.T) {
+		url, err := waitForReady(strings.NewReader("OK http://localhost:6333/\n"), time.Second)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if url != "http://localhost:6333/" {
+			t.Errorf("Expected daemon URL, got %s", url)
+		}
+	})
+
+	t.Run("StartupError", func(t *testing.T) {
+		_, err := waitForReady(strings.NewReader("ERROR address already in use\n"), time.Second)
+		if err == nil || err.Error() != "address already in use" {
+			t.Errorf("Expected reported error, got %v", err)
+		}
+	})
+
+	t.Run("ChildExitedWithoutReport", func(t *testing.T) {
+		_, err := waitForReady(strings.NewReader(""), time.Second)
+		if err == nil || !strings.Contains(err.Error(), "exited before it was ready") {
+			t.Errorf("Expected early exit error, got %v", err)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		reader, writer := io.Pipe()
+		defer func() { _ = writer.Close() }()
+
+		_, err := waitForReady(reader, 50*time.Millisecond)
+		if err == nil || !strings.Contains(err.Error(), "did not become ready") {
+			t.Errorf("Expected timeout error, got %v", err)
+		}
+	})
+
+	t.Run("UnexpectedReport", func(t *testing.T) {
+		if _, err := waitForReady(strings.NewReader("garbage\n"), time.Second); err == nil {
+			t.Error("Expected error for unexpected report")
+		}
+	})
+}
+
+func TestReportReadiness(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = reader.Close() }()
+
+	readyPipe = writer
+	t.Cleanup(func() { readyPipe = nil })
+
+	reportStartupError(errors.New("bind failed\nretry later"))
+	// Only the first report reaches the parent
+	reportReady("http://localhost:6333/")
+
+	if readyPipe != nil {
+		t.Error("Expected readiness pipe to be released after reporting")
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "ERROR bind failed retry later\n" {
+		t.Errorf("Unexpected readiness report: %q", data)
+	}
+}
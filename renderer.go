@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/yuin/goldmark"
 	highlighting "github.com/yuin/goldmark-highlighting/v2"
@@ -50,21 +51,50 @@ func renderMarkdown(filePath string) error {
 		return fmt.Errorf("file not tracked: %s", filePath)
 	}
 
+	// Documents read from stdin have no file to read, their content is kept in memory
+	if fileState.stdin {
+		fileState.contentLock.RLock()
+		source := fileState.source
+		fileState.contentLock.RUnlock()
+		return fileState.render(source, false)
+	}
+
 	// Read and render the file (without holding any locks)
 	content, err := os.ReadFile(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to read file: %w", err)
+		err = fmt.Errorf("failed to read file: %w", err)
+		fileState.setRenderError(err)
+		return err
 	}
 
+	return fileState.render(content, false)
+}
+
+// render converts Markdown content to HTML and makes it the file's current content. unsaved marks content
+// pushed from an editor buffer rather than read from the saved file.
+func (fs *FileState) render(content []byte, unsaved bool) error {
 	var buf bytes.Buffer
 	if err := md.Convert(content, &buf); err != nil {
-		return fmt.Errorf("failed to convert markdown: %w", err)
+		err = fmt.Errorf("failed to convert markdown: %w", err)
+		fs.setRenderError(err)
+		return err
 	}
 
 	// Update the HTML content with the file's lock
-	fileState.contentLock.Lock()
-	fileState.htmlContent = buf.Bytes()
-	fileState.contentLock.Unlock()
+	fs.contentLock.Lock()
+	fs.htmlContent = buf.Bytes()
+	fs.renderedAt = time.Now()
+	fs.renderErr = nil
+	fs.unsaved = unsaved
+	fs.contentLock.Unlock()
 
 	return nil
 }
+
+// setRenderError records the error of the last failed render. The previously rendered
+// content is kept so that viewers still see the last good version of the file.
+func (fs *FileState) setRenderError(err error) {
+	fs.contentLock.Lock()
+	fs.renderErr = err
+	fs.contentLock.Unlock()
+}
@@ -390,4 +390,64 @@ Math: x < y > z
 		delete(files, testFile)
 		filesLock.Unlock()
 	})
+
+	t.Run("RecordsRenderStatus", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		testFile := filepath.Join(tmpDir, "status.md")
+
+		if err := os.WriteFile(testFile, []byte("# Status"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		// Add to tracking
+		filesLock.Lock()
+		fileState := &FileState{
+			path:       testFile,
+			sseClients: make(map[chan string]bool),
+		}
+		files[testFile] = fileState
+		filesLock.Unlock()
+
+		defer func() {
+			filesLock.Lock()
+			delete(files, testFile)
+			filesLock.Unlock()
+		}()
+
+		if err := renderMarkdown(testFile); err != nil {
+			t.Fatalf("Failed to render: %v", err)
+		}
+
+		fileState.contentLock.RLock()
+		renderedAt := fileState.renderedAt
+		renderErr := fileState.renderErr
+		fileState.contentLock.RUnlock()
+
+		if renderedAt.IsZero() {
+			t.Error("Render time should be recorded after a successful render")
+		}
+		if renderErr != nil {
+			t.Errorf("Render error should be empty after a successful render, got: %v", renderErr)
+		}
+
+		// Make the next render fail
+		if err := os.Remove(testFile); err != nil {
+			t.Fatal(err)
+		}
+		if err := renderMarkdown(testFile); err == nil {
+			t.Fatal("Expected error when file is missing")
+		}
+
+		fileState.contentLock.RLock()
+		renderErr = fileState.renderErr
+		content := string(fileState.htmlContent)
+		fileState.contentLock.RUnlock()
+
+		if renderErr == nil {
+			t.Error("Render error should be recorded after a failed render")
+		}
+		if !strings.Contains(content, "Status") {
+			t.Error("Previously rendered content should be kept after a failed render")
+		}
+	})
 }
@@ -5,9 +5,13 @@ import (
 	"fmt"
 	"html/template"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -19,18 +23,49 @@ var assets embed.FS
 
 // FileState holds the state for a single tracked markdown file
 type FileState struct {
-	path        string
+	path    string
+	addedAt time.Time
+	// dir is the tracked directory the file was found in, empty for files added on their own
+	dir string
+	// stdin documents were read from the standard input of a lum invocation. Their path is a generated ID and
+	// source holds their Markdown content, guarded by contentLock.
+	stdin       bool
+	source      []byte
 	htmlContent []byte
+	renderedAt  time.Time
+	renderErr   error
+	// unsaved is set while htmlContent shows an editor buffer pushed over the control socket instead of the
+	// saved file
+	unsaved     bool
 	contentLock sync.RWMutex
 	watcher     *fsnotify.Watcher
 	sseClients  map[chan string]bool
 	clientsLock sync.RWMutex
 }
 
+// trackedFileInfo describes a tracked file as reported by the LIST control command
+type trackedFileInfo struct {
+	Path       string    `json:"path"`
+	URL        string    `json:"url"`
+	AddedAt    time.Time `json:"added_at"`
+	RenderedAt time.Time `json:"rendered_at"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Viewers    int       `json:"viewers"`
+	Directory  string    `json:"directory,omitempty"`
+	Stdin      bool      `json:"stdin,omitempty"`
+	Unsaved    bool      `json:"unsaved,omitempty"`
+}
+
 var (
 	// Version is set via ldflags during build
 	Version = "dev"
 
+	// startedAt and serverAddr describe the running daemon and are reported by the STATUS command.
+	// serverAddr is the address the HTTP listener is actually bound to.
+	startedAt  time.Time
+	serverAddr string
+
 	files     = make(map[string]*FileState)
 	filesLock sync.RWMutex
 
@@ -58,18 +93,32 @@ func init() {
 }
 
 // addFile adds a new file to the tracked files, renders it, and starts watching it.
-// If the file is already tracked, this is a no-op and returns nil.
+// If the file is already tracked, this is a no-op and returns nil, except that a file tracked because it is in a
+// tracked directory becomes a file of its own.
 func addFile(filePath string) error {
+	return trackFile(filePath, "")
+}
+
+// trackFile adds a file found in the tracked directory dir, or a file added on its own if dir is empty.
+// Files in a tracked directory are watched by the directory's watcher and are not persisted on their own.
+// A file that was added on its own keeps its own watcher when a directory holding it is scanned.
+func trackFile(filePath, dir string) error {
 	filesLock.Lock()
 	// Check if file is already tracked
-	if _, exists := files[filePath]; exists {
+	if existing, exists := files[filePath]; exists {
+		if dir != "" || existing.dir == "" {
+			filesLock.Unlock()
+			return nil
+		}
 		filesLock.Unlock()
-		return nil
+		return detachFromDirectory(filePath)
 	}
 
 	// Create new file state
 	fileState := &FileState{
 		path:       filePath,
+		addedAt:    time.Now(),
+		dir:        dir,
 		sseClients: make(map[chan string]bool),
 	}
 	files[filePath] = fileState
@@ -84,19 +133,169 @@ func addFile(filePath string) error {
 	}
 
 	// Start watching the file
+	if dir == "" {
+		if err := startWatchingFile(filePath); err != nil {
+			filesLock.Lock()
+			delete(files, filePath)
+			filesLock.Unlock()
+			return fmt.Errorf("failed to start watching file: %w", err)
+		}
+	}
+
+	// Notify index page clients that a new file was added
+	notifyIndexClients("reload")
+
+	if dir == "" {
+		saveState()
+	}
+
+	return nil
+}
+
+// detachFromDirectory turns a file tracked because it is in a tracked directory into a file of its own, which is
+// watched and persisted separately and stays tracked when the directory is removed
+func detachFromDirectory(filePath string) error {
+	filesLock.Lock()
+	fileState, exists := files[filePath]
+	if !exists || fileState.dir == "" {
+		filesLock.Unlock()
+		return nil
+	}
+	dir := fileState.dir
+	fileState.dir = ""
+	filesLock.Unlock()
+
 	if err := startWatchingFile(filePath); err != nil {
 		filesLock.Lock()
-		delete(files, filePath)
+		fileState.dir = dir
 		filesLock.Unlock()
 		return fmt.Errorf("failed to start watching file: %w", err)
 	}
 
-	// Notify index page clients that a new file was added
 	notifyIndexClients("reload")
+	saveState()
 
 	return nil
 }
 
+// removeFile stops tracking a file: its watcher is closed, its SSE clients are sent a
+// "removed" event, and index page clients are told to reload.
+func removeFile(filePath string) error {
+	filesLock.Lock()
+	fileState, exists := files[filePath]
+	if !exists {
+		filesLock.Unlock()
+		return fmt.Errorf("file not tracked: %s", filePath)
+	}
+	delete(files, filePath)
+	watcher := fileState.watcher
+	filesLock.Unlock()
+
+	// Closing the watcher also ends the goroutine started by startWatchingFile
+	if watcher != nil {
+		if err := watcher.Close(); err != nil {
+			slog.Error("Failed to close watcher", "error", err)
+		}
+	}
+
+	fileState.clientsLock.RLock()
+	for client := range fileState.sseClients {
+		select {
+		case client <- "removed":
+		default:
+		}
+	}
+	fileState.clientsLock.RUnlock()
+
+	// Notify index page clients that a file was removed
+	notifyIndexClients("reload")
+
+	saveState()
+
+	return nil
+}
+
+// listFiles returns information about every tracked file, sorted by path
+func listFiles(port int) []trackedFileInfo {
+	filesLock.RLock()
+	defer filesLock.RUnlock()
+
+	list := make([]trackedFileInfo, 0, len(files))
+	for path, fileState := range files {
+		list = append(list, describeFile(port, path, fileState))
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Path < list[j].Path
+	})
+
+	return list
+}
+
+// describeFile returns information about a tracked file
+func describeFile(port int, path string, fileState *FileState) trackedFileInfo {
+	info := trackedFileInfo{
+		Path:      path,
+		URL:       fileURL(port, path),
+		AddedAt:   fileState.addedAt,
+		Status:    "ok",
+		Directory: fileState.dir,
+		Stdin:     fileState.stdin,
+	}
+
+	fileState.contentLock.RLock()
+	info.RenderedAt = fileState.renderedAt
+	info.Unsaved = fileState.unsaved
+	if fileState.renderErr != nil {
+		info.Status = "error"
+		info.Error = fileState.renderErr.Error()
+	}
+	fileState.contentLock.RUnlock()
+
+	fileState.clientsLock.RLock()
+	info.Viewers = len(fileState.sseClients)
+	fileState.clientsLock.RUnlock()
+
+	return info
+}
+
+// serverPort returns the port the HTTP listener of the running server is bound to, 0 before it is bound
+func serverPort() int {
+	_, portValue, err := net.SplitHostPort(serverAddr)
+	if err != nil {
+		return 0
+	}
+
+	port, err := strconv.Atoi(portValue)
+	if err != nil {
+		return 0
+	}
+
+	return port
+}
+
+// fileURL returns the URL at which a tracked file is served
+func fileURL(port int, filePath string) string {
+	return fmt.Sprintf("http://%s/?file=%s", serverURLHost(port), filePath)
+}
+
+// indexURL returns the URL of the index page listing all tracked files
+func indexURL(port int) string {
+	return fmt.Sprintf("http://%s/", serverURLHost(port))
+}
+
+// serverURLHost returns the host and port that URLs of the running server point at. A server on the IPv4
+// loopback or an unspecified address is reached as localhost, a server on any other address at that address.
+func serverURLHost(port int) string {
+	host, _, err := net.SplitHostPort(serverAddr)
+	ip := net.ParseIP(host)
+	if err != nil || ip == nil || ip.IsUnspecified() || (ip.IsLoopback() && ip.To4() != nil) {
+		return fmt.Sprintf("localhost:%d", port)
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
 // handleIndex serves either a specific file (if ?file= query param is present),
 // an index page listing all tracked files, or static assets relative to the Markdown file
 func handleIndex(w http.ResponseWriter, r *http.Request) {
@@ -133,17 +332,23 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 	// Read content with the file's lock
 	fileState.contentLock.RLock()
 	content := fileState.htmlContent
+	unsaved := fileState.unsaved
 	fileState.contentLock.RUnlock()
 
+	title := filepath.Base(filePath)
+	if fileState.stdin {
+		title = stdinLabel
+	}
+
 	cssContent, err := assets.ReadFile("assets/style.css")
 	if err != nil {
-		log.Printf("Failed to read CSS: %v", err)
+		slog.Error("Failed to read CSS", "error", err)
 		cssContent = []byte("")
 	}
 
 	jsContent, err := assets.ReadFile("assets/script.js")
 	if err != nil {
-		log.Printf("Failed to read JavaScript: %v", err)
+		slog.Error("Failed to read JavaScript", "error", err)
 		jsContent = []byte("")
 	}
 
@@ -155,28 +360,32 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 		Content template.HTML
 		JS      template.JS
 		File    string
+		Stdin   bool
+		Unsaved bool
 	}{
-		Title:   filepath.Base(filePath),
+		Title:   title,
 		CSS:     template.CSS(cssContent),
 		Content: template.HTML(content),
 		JS:      template.JS(jsContent),
 		File:    filePath,
+		Stdin:   fileState.stdin,
+		Unsaved: unsaved,
 	}
 
 	if err := fileTemplate.Execute(w, data); err != nil {
-		log.Printf("Failed to execute file template: %v", err)
+		slog.Error("Failed to execute file template", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
 
 // handleStaticAsset serves a static file relative to the Markdown file's directory
 func handleStaticAsset(w http.ResponseWriter, r *http.Request, markdownFilePath string) {
-	// Verify the markdown file is tracked
+	// Verify the markdown file is tracked. Documents read from stdin have no directory to serve assets from.
 	filesLock.RLock()
-	_, exists := files[markdownFilePath]
+	fileState, exists := files[markdownFilePath]
 	filesLock.RUnlock()
 
-	if !exists {
+	if !exists || fileState.stdin {
 		http.NotFound(w, r)
 		return
 	}
@@ -254,21 +463,23 @@ func renderIndexPage(w http.ResponseWriter, r *http.Request) {
 	defer filesLock.RUnlock()
 
 	type FileInfo struct {
-		Name string
-		Path string
+		Name  string
+		Path  string
+		Stdin bool
 	}
 
 	var fileList []FileInfo
-	for path := range files {
+	for path, fileState := range files {
 		fileList = append(fileList, FileInfo{
-			Name: filepath.Base(path),
-			Path: path,
+			Name:  filepath.Base(path),
+			Path:  path,
+			Stdin: fileState.stdin,
 		})
 	}
 
 	cssContent, err := assets.ReadFile("assets/style.css")
 	if err != nil {
-		log.Printf("Failed to read CSS: %v", err)
+		slog.Error("Failed to read CSS", "error", err)
 		cssContent = []byte("")
 	}
 
@@ -283,7 +494,7 @@ func renderIndexPage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := indexTemplate.Execute(w, data); err != nil {
-		log.Printf("Failed to execute index template: %v", err)
+		slog.Error("Failed to execute index template", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
@@ -322,6 +533,11 @@ func handleSSE(w http.ResponseWriter, r *http.Request) {
 		fileState.clientsLock.Unlock()
 	}()
 
+	// Send the headers right away, so that browsers see the connection open (and reload after a restart)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
 	// Keep connection alive
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
@@ -330,15 +546,19 @@ func handleSSE(w http.ResponseWriter, r *http.Request) {
 		select {
 		case msg := <-clientChan:
 			if _, err := fmt.Fprintf(w, "data: %s\n\n", msg); err != nil {
-				log.Printf("Error writing SSE message: %v", err)
+				slog.Debug("Failed to write SSE message", "error", err)
 				return
 			}
 			if f, ok := w.(http.Flusher); ok {
 				f.Flush()
 			}
+			// The file is no longer tracked or the server is going away, so there is nothing left to stream
+			if msg == "removed" || msg == "server-stopping" {
+				return
+			}
 		case <-ticker.C:
 			if _, err := fmt.Fprintf(w, ": keepalive\n\n"); err != nil {
-				log.Printf("Error writing keepalive: %v", err)
+				slog.Debug("Failed to write keepalive", "error", err)
 				return
 			}
 			if f, ok := w.(http.Flusher); ok {
@@ -390,6 +610,11 @@ func handleIndexSSE(w http.ResponseWriter, r *http.Request) {
 		indexSSEClientsLock.Unlock()
 	}()
 
+	// Send the headers right away, so that browsers see the connection open (and reload after a restart)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
 	// Keep connection alive
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
@@ -398,15 +623,18 @@ func handleIndexSSE(w http.ResponseWriter, r *http.Request) {
 		select {
 		case msg := <-clientChan:
 			if _, err := fmt.Fprintf(w, "data: %s\n\n", msg); err != nil {
-				log.Printf("Error writing SSE message: %v", err)
+				slog.Debug("Failed to write SSE message", "error", err)
 				return
 			}
 			if f, ok := w.(http.Flusher); ok {
 				f.Flush()
 			}
+			if msg == "server-stopping" {
+				return
+			}
 		case <-ticker.C:
 			if _, err := fmt.Fprintf(w, ": keepalive\n\n"); err != nil {
-				log.Printf("Error writing keepalive: %v", err)
+				slog.Debug("Failed to write keepalive", "error", err)
 				return
 			}
 			if f, ok := w.(http.Flusher); ok {
@@ -431,6 +659,41 @@ func notifyIndexClients(message string) {
 	}
 }
 
+// notifyAllClients sends a message to the SSE clients of every tracked file and of the index page
+func notifyAllClients(message string) {
+	filesLock.RLock()
+	for _, fileState := range files {
+		fileState.clientsLock.RLock()
+		for client := range fileState.sseClients {
+			select {
+			case client <- message:
+			default:
+			}
+		}
+		fileState.clientsLock.RUnlock()
+	}
+	filesLock.RUnlock()
+
+	notifyIndexClients(message)
+}
+
+// stopWatchingFiles closes the watchers of all tracked files. The files stay tracked, so that
+// the state persisted for the next start is unaffected.
+func stopWatchingFiles() {
+	filesLock.Lock()
+	defer filesLock.Unlock()
+
+	for _, fileState := range files {
+		if fileState.watcher == nil {
+			continue
+		}
+		if err := fileState.watcher.Close(); err != nil {
+			slog.Error("Failed to close watcher", "error", err)
+		}
+		fileState.watcher = nil
+	}
+}
+
 // isPathWithinDirectory checks if path is within dir or its subdirectories
 func isPathWithinDirectory(path, dir string) bool {
 	// Get absolute paths
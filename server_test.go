@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -120,6 +121,156 @@ func TestAddFile(t *testing.T) {
 	})
 }
 
+func TestRemoveFile(t *testing.T) {
+	t.Run("RemoveTrackedFile", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		testFile := filepath.Join(tmpDir, "test.md")
+
+		if err := os.WriteFile(testFile, []byte("# Test"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := addFile(testFile); err != nil {
+			t.Fatalf("Failed to add file: %v", err)
+		}
+
+		// Attach mock clients to the file and the index page
+		clientChan := make(chan string, 1)
+		filesLock.RLock()
+		fileState := files[testFile]
+		filesLock.RUnlock()
+		fileState.clientsLock.Lock()
+		fileState.sseClients[clientChan] = true
+		fileState.clientsLock.Unlock()
+
+		indexChan := make(chan string, 1)
+		indexSSEClientsLock.Lock()
+		indexSSEClients[indexChan] = true
+		indexSSEClientsLock.Unlock()
+
+		defer func() {
+			indexSSEClientsLock.Lock()
+			delete(indexSSEClients, indexChan)
+			indexSSEClientsLock.Unlock()
+		}()
+
+		if err := removeFile(testFile); err != nil {
+			t.Fatalf("Failed to remove file: %v", err)
+		}
+
+		filesLock.RLock()
+		_, exists := files[testFile]
+		filesLock.RUnlock()
+
+		if exists {
+			t.Error("File should not be tracked after removing")
+		}
+
+		select {
+		case msg := <-clientChan:
+			if msg != "removed" {
+				t.Errorf("Expected 'removed', got '%s'", msg)
+			}
+		default:
+			t.Error("File client received no message")
+		}
+
+		select {
+		case msg := <-indexChan:
+			if msg != "reload" {
+				t.Errorf("Expected 'reload', got '%s'", msg)
+			}
+		default:
+			t.Error("Index client received no message")
+		}
+	})
+
+	t.Run("RemoveUntrackedFile", func(t *testing.T) {
+		err := removeFile("/nonexistent-remove-file-12345.md")
+		if err == nil {
+			t.Error("Expected error when removing untracked file")
+		}
+	})
+}
+
+func TestListFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	file1 := filepath.Join(tmpDir, "b.md")
+	file2 := filepath.Join(tmpDir, "a.md")
+	renderedAt := time.Now()
+
+	clientChan := make(chan string)
+	filesLock.Lock()
+	originalFiles := files
+	files = map[string]*FileState{
+		file1: {
+			path:       file1,
+			renderedAt: renderedAt,
+			sseClients: map[chan string]bool{clientChan: true},
+		},
+		file2: {
+			path:       file2,
+			renderErr:  errors.New("failed to read file"),
+			sseClients: make(map[chan string]bool),
+		},
+	}
+	filesLock.Unlock()
+
+	defer func() {
+		filesLock.Lock()
+		files = originalFiles
+		filesLock.Unlock()
+	}()
+
+	list := listFiles(6333)
+
+	if len(list) != 2 {
+		t.Fatalf("Expected 2 files, got %d", len(list))
+	}
+
+	// Sorted by path
+	if list[0].Path != file2 || list[1].Path != file1 {
+		t.Errorf("Expected files sorted by path, got %s, %s", list[0].Path, list[1].Path)
+	}
+
+	if list[0].Status != "error" || list[0].Error != "failed to read file" {
+		t.Errorf("Expected error status, got %q (%q)", list[0].Status, list[0].Error)
+	}
+
+	if list[1].Status != "ok" {
+		t.Errorf("Expected ok status, got %q", list[1].Status)
+	}
+	if list[1].Viewers != 1 {
+		t.Errorf("Expected 1 viewer, got %d", list[1].Viewers)
+	}
+	if !list[1].RenderedAt.Equal(renderedAt) {
+		t.Errorf("Expected render time %v, got %v", renderedAt, list[1].RenderedAt)
+	}
+
+	expectedURL := "http://localhost:6333/?file=" + file1
+	if list[1].URL != expectedURL {
+		t.Errorf("Expected URL %s, got %s", expectedURL, list[1].URL)
+	}
+}
+
+func TestServerPort(t *testing.T) {
+	oldAddr := serverAddr
+	defer func() { serverAddr = oldAddr }()
+
+	tests := map[string]int{
+		"127.0.0.1:16333": 16333,
+		"[::1]:8080":      8080,
+		"":                0,
+		"localhost":       0,
+	}
+	for addr, expected := range tests {
+		serverAddr = addr
+		if port := serverPort(); port != expected {
+			t.Errorf("serverPort() for %q = %d, expected %d", addr, port, expected)
+		}
+	}
+}
+
 func TestHandleIndex(t *testing.T) {
 	t.Run("IndexWithNoFiles", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "/", nil)
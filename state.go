@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// persistedState is the content of the state file kept in the state directory.
+// It lets a restarted daemon pick up the files it was serving before, also after a reboot.
+type persistedState struct {
+	// Port is the port the daemon was bound to, reused when it is started with --port auto
+	Port  int             `json:"port,omitempty"`
+	Files []persistedFile `json:"files"`
+	// Dirs are the tracked directories, whose files are found again when the directory is restored
+	Dirs []persistedDir `json:"dirs,omitempty"`
+}
+
+// persistedFile is a tracked file as recorded in the state file. Documents read from stdin are recorded with
+// their content, as there is no file to read it from again.
+type persistedFile struct {
+	Path    string    `json:"path"`
+	AddedAt time.Time `json:"added_at"`
+	Stdin   bool      `json:"stdin,omitempty"`
+	Content string    `json:"content,omitempty"`
+}
+
+// persistedDir is a tracked directory as recorded in the state file
+type persistedDir struct {
+	Path    string    `json:"path"`
+	AddedAt time.Time `json:"added_at"`
+	directoryFilter
+}
+
+var (
+	// statePath is the state file the daemon persists its tracked files to. It is empty
+	// (persistence disabled) until startDaemon sets it, so one-off servers never write state.
+	statePath string
+	stateLock sync.Mutex
+
+	// restoring suppresses saving while restoreState adds the recorded files one by one, so that the state file
+	// is only replaced once the restored set is complete
+	restoring bool
+)
+
+// getStateDir returns the directory holding the state file of the selected instance. Unlike the runtime
+// directory, which is emptied on logout and reboot, it is kept in $XDG_STATE_HOME (~/.local/state by default).
+func getStateDir() (string, error) {
+	baseDir := os.Getenv("XDG_STATE_HOME")
+	// The XDG base directory specification requires relative paths to be ignored
+	if !filepath.IsAbs(baseDir) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		baseDir = filepath.Join(home, ".local", "state")
+	}
+
+	dir := filepath.Join(baseDir, "lum")
+	if instanceName != defaultInstance {
+		dir = filepath.Join(dir, "instances", instanceName)
+	}
+
+	return dir, nil
+}
+
+// getStatePath returns the path of the state file of the selected instance, creating its directory
+func getStatePath() (string, error) {
+	dir, err := getStateDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get state directory: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	return filepath.Join(dir, "state.json"), nil
+}
+
+// saveState writes the current set of tracked files to the state file if persistence is enabled.
+// Failures are logged rather than returned, as they must not affect serving files.
+func saveState() {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	if statePath == "" || restoring {
+		return
+	}
+
+	state := collectState()
+	if err := writeStateFile(statePath, &state); err != nil {
+		slog.Error("Failed to save state", "error", err)
+	}
+}
+
+// collectState returns the current set of tracked files as recorded in the state file
+func collectState() persistedState {
+	filesLock.RLock()
+	state := persistedState{Port: serverPort(), Files: make([]persistedFile, 0, len(files))}
+	for path, fileState := range files {
+		if fileState.dir != "" {
+			continue
+		}
+		file := persistedFile{Path: path, AddedAt: fileState.addedAt}
+		if fileState.stdin {
+			file.Stdin = true
+			fileState.contentLock.RLock()
+			file.Content = string(fileState.source)
+			fileState.contentLock.RUnlock()
+		}
+		state.Files = append(state.Files, file)
+	}
+	filesLock.RUnlock()
+
+	dirsLock.Lock()
+	for path, dir := range dirs {
+		state.Dirs = append(state.Dirs, persistedDir{
+			Path:            path,
+			AddedAt:         dir.addedAt,
+			directoryFilter: dir.filter,
+		})
+	}
+	dirsLock.Unlock()
+
+	sortState(&state)
+	return state
+}
+
+// sortState sorts the files and directories of state by path
+func sortState(state *persistedState) {
+	sort.Slice(state.Files, func(i, j int) bool {
+		return state.Files[i].Path < state.Files[j].Path
+	})
+	sort.Slice(state.Dirs, func(i, j int) bool {
+		return state.Dirs[i].Path < state.Dirs[j].Path
+	})
+}
+
+// mergeState adds the current set of tracked files to the state file at path, keeping the files recorded there.
+// A one-off server uses it to hand its files over to the daemon it is promoted to, which restores both.
+func mergeState(path string) error {
+	state := collectState()
+
+	recorded, err := readStateFile(path)
+	if err != nil {
+		return err
+	}
+	if recorded != nil {
+		tracked := make(map[string]bool)
+		for _, file := range state.Files {
+			tracked[file.Path] = true
+		}
+		for _, dir := range state.Dirs {
+			tracked[dir.Path] = true
+		}
+
+		for _, file := range recorded.Files {
+			if !tracked[file.Path] {
+				state.Files = append(state.Files, file)
+			}
+		}
+		for _, dir := range recorded.Dirs {
+			if !tracked[dir.Path] {
+				state.Dirs = append(state.Dirs, dir)
+			}
+		}
+		sortState(&state)
+	}
+
+	return writeStateFile(path, &state)
+}
+
+// writeStateFile atomically replaces the state file at statePath with the given state
+func writeStateFile(statePath string, state *persistedState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+	data = append(data, '\n')
+
+	// Write to a temporary file and rename it so readers never see a partial file
+	tmpPath := statePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmpPath, statePath); err != nil {
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+
+	return nil
+}
+
+// readState reads the state file. Returns nil if persistence is disabled or no state has been recorded.
+func readState() (*persistedState, error) {
+	stateLock.Lock()
+	path := statePath
+	stateLock.Unlock()
+
+	if path == "" {
+		return nil, nil
+	}
+
+	return readStateFile(path)
+}
+
+// readStateFile reads the state file at path. Returns nil if no state has been recorded.
+func readStateFile(path string) (*persistedState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	var state persistedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to decode state file: %w", err)
+	}
+
+	return &state, nil
+}
+
+// restoreState re-adds the files recorded in the state file. Files that no longer exist
+// or fail to render are skipped and logged. The state file is written once, after every file has been restored.
+func restoreState() error {
+	state, err := readState()
+	if err != nil || state == nil {
+		return err
+	}
+
+	stateLock.Lock()
+	restoring = true
+	stateLock.Unlock()
+	defer func() {
+		stateLock.Lock()
+		restoring = false
+		stateLock.Unlock()
+
+		// Persist the restored set so that skipped files are dropped and original add times are kept
+		saveState()
+	}()
+
+	for _, file := range state.Files {
+		if file.Stdin {
+			if err := trackStdinDocument(file.Path, []byte(file.Content), file.AddedAt); err != nil {
+				slog.Warn("Skipping restored document", "id", file.Path, "error", err)
+				continue
+			}
+			slog.Info("Restored document read from stdin", "id", file.Path)
+			continue
+		}
+
+		if _, err := os.Stat(file.Path); err != nil {
+			slog.Warn("Skipping restored file", "path", file.Path, "error", err)
+			continue
+		}
+
+		if err := addFile(file.Path); err != nil {
+			slog.Warn("Skipping restored file", "path", file.Path, "error", err)
+			continue
+		}
+
+		// Keep the original time the file was added rather than the time of the restart
+		filesLock.Lock()
+		if fileState, exists := files[file.Path]; exists && !file.AddedAt.IsZero() {
+			fileState.addedAt = file.AddedAt
+		}
+		filesLock.Unlock()
+
+		slog.Info("Restored file", "path", file.Path)
+	}
+
+	for _, dir := range state.Dirs {
+		if err := addDirectory(dir.Path, dir.directoryFilter); err != nil {
+			slog.Warn("Skipping restored directory", "path", dir.Path, "error", err)
+			continue
+		}
+
+		dirsLock.Lock()
+		if trackedDir, exists := dirs[dir.Path]; exists && !dir.AddedAt.IsZero() {
+			trackedDir.addedAt = dir.AddedAt
+		}
+		dirsLock.Unlock()
+
+		slog.Info("Restored directory", "path", dir.Path)
+	}
+
+	return nil
+}
+
+// loadStatePort returns the port recorded in the state file, or 0 if none is recorded
+func loadStatePort() int {
+	state, err := readState()
+	if err != nil || state == nil {
+		return 0
+	}
+
+	return state.Port
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestSaveAndRestoreState(t *testing.T) {
+	tmpRuntimeDir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	oldXDG := os.Getenv("XDG_RUNTIME_DIR")
+	if err := os.Setenv("XDG_RUNTIME_DIR", tmpRuntimeDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if oldXDG != "" {
+			_ = os.Setenv("XDG_RUNTIME_DIR", oldXDG)
+		} else {
+			_ = os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+	})
+
+	tmpDir := t.TempDir()
+	keptFile := filepath.Join(tmpDir, "kept.md")
+	deletedFile := filepath.Join(tmpDir, "deleted.md")
+
+	for _, path := range []string{keptFile, deletedFile} {
+		if err := os.WriteFile(path, []byte("# Test"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	addedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// Start from an isolated set of tracked files
+	filesLock.Lock()
+	originalFiles := files
+	files = map[string]*FileState{
+		keptFile:    {path: keptFile, addedAt: addedAt, sseClients: make(map[chan string]bool)},
+		deletedFile: {path: deletedFile, addedAt: addedAt, sseClients: make(map[chan string]bool)},
+	}
+	filesLock.Unlock()
+
+	path, err := getStatePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateLock.Lock()
+	statePath = path
+	stateLock.Unlock()
+
+	defer func() {
+		stateLock.Lock()
+		statePath = ""
+		stateLock.Unlock()
+		filesLock.Lock()
+		for _, fileState := range files {
+			if fileState.watcher != nil {
+				_ = fileState.watcher.Close()
+			}
+		}
+		files = originalFiles
+		filesLock.Unlock()
+	}()
+
+	t.Run("SaveState", func(t *testing.T) {
+		saveState()
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("State file should be written: %v", err)
+		}
+
+		var state persistedState
+		if err := json.Unmarshal(data, &state); err != nil {
+			t.Fatalf("State file is not valid JSON: %v", err)
+		}
+
+		if len(state.Files) != 2 {
+			t.Fatalf("Expected 2 files in state, got %d", len(state.Files))
+		}
+		// Sorted by path
+		if state.Files[0].Path != deletedFile || state.Files[1].Path != keptFile {
+			t.Errorf("Unexpected files in state: %+v", state.Files)
+		}
+		if !state.Files[1].AddedAt.Equal(addedAt) {
+			t.Errorf("Expected added time %v, got %v", addedAt, state.Files[1].AddedAt)
+		}
+	})
+
+	t.Run("RestoreState", func(t *testing.T) {
+		if err := os.Remove(deletedFile); err != nil {
+			t.Fatal(err)
+		}
+
+		filesLock.Lock()
+		files = make(map[string]*FileState)
+		filesLock.Unlock()
+
+		if err := restoreState(); err != nil {
+			t.Fatalf("Failed to restore state: %v", err)
+		}
+
+		filesLock.RLock()
+		kept, keptExists := files[keptFile]
+		_, deletedExists := files[deletedFile]
+		filesLock.RUnlock()
+
+		if !keptExists {
+			t.Fatal("Existing file should be restored")
+		}
+		if deletedExists {
+			t.Error("Deleted file should be skipped")
+		}
+		if !kept.addedAt.Equal(addedAt) {
+			t.Errorf("Expected original added time %v, got %v", addedAt, kept.addedAt)
+		}
+
+		// The skipped file is dropped from the state file
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var state persistedState
+		if err := json.Unmarshal(data, &state); err != nil {
+			t.Fatal(err)
+		}
+		if len(state.Files) != 1 || state.Files[0].Path != keptFile {
+			t.Errorf("Expected only %s in state, got %+v", keptFile, state.Files)
+		}
+	})
+
+	t.Run("RestoreWithoutStateFile", func(t *testing.T) {
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := restoreState(); err != nil {
+			t.Errorf("Missing state file should not be an error: %v", err)
+		}
+	})
+}
+
+func TestSaveAndRestoreDirectories(t *testing.T) {
+	tmpRuntimeDir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	oldXDG := os.Getenv("XDG_RUNTIME_DIR")
+	if err := os.Setenv("XDG_RUNTIME_DIR", tmpRuntimeDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if oldXDG != "" {
+			_ = os.Setenv("XDG_RUNTIME_DIR", oldXDG)
+		} else {
+			_ = os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+	})
+
+	docsDir := t.TempDir()
+	docFile := filepath.Join(docsDir, "a.md")
+	if err := os.WriteFile(docFile, []byte("# Test"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Start from an isolated set of tracked files
+	filesLock.Lock()
+	originalFiles := files
+	files = make(map[string]*FileState)
+	filesLock.Unlock()
+
+	path, err := getStatePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateLock.Lock()
+	statePath = path
+	stateLock.Unlock()
+
+	defer func() {
+		_ = removeDirectory(docsDir)
+		stateLock.Lock()
+		statePath = ""
+		stateLock.Unlock()
+		filesLock.Lock()
+		files = originalFiles
+		filesLock.Unlock()
+	}()
+
+	if err := addDirectory(docsDir, directoryFilter{Exclude: []string{"drafts"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("State file should be written: %v", err)
+	}
+	var state persistedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("State file is not valid JSON: %v", err)
+	}
+	if len(state.Files) != 0 {
+		t.Errorf("Expected files in tracked directories not to be persisted, got %+v", state.Files)
+	}
+	if len(state.Dirs) != 1 || state.Dirs[0].Path != docsDir || len(state.Dirs[0].Exclude) != 1 {
+		t.Fatalf("Expected the directory with its filter in state, got %+v", state.Dirs)
+	}
+
+	// Forget the directory as a restarted daemon would
+	dirsLock.Lock()
+	_ = dirs[docsDir].watcher.Close()
+	delete(dirs, docsDir)
+	dirsLock.Unlock()
+	filesLock.Lock()
+	files = make(map[string]*FileState)
+	filesLock.Unlock()
+
+	if err := restoreState(); err != nil {
+		t.Fatalf("Failed to restore state: %v", err)
+	}
+	if !isTrackedDirectory(docsDir) {
+		t.Fatal("Expected the directory to be restored")
+	}
+	filesLock.RLock()
+	fileState, exists := files[docFile]
+	filesLock.RUnlock()
+	if !exists || fileState.dir != docsDir {
+		t.Error("Expected the directory's files to be found again")
+	}
+}
+
+func TestSaveAndRestoreStdinDocuments(t *testing.T) {
+	tmpRuntimeDir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	oldXDG := os.Getenv("XDG_RUNTIME_DIR")
+	if err := os.Setenv("XDG_RUNTIME_DIR", tmpRuntimeDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if oldXDG != "" {
+			_ = os.Setenv("XDG_RUNTIME_DIR", oldXDG)
+		} else {
+			_ = os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+	})
+
+	// Start from an isolated set of tracked files
+	filesLock.Lock()
+	originalFiles := files
+	files = make(map[string]*FileState)
+	filesLock.Unlock()
+
+	path, err := getStatePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateLock.Lock()
+	statePath = path
+	stateLock.Unlock()
+
+	defer func() {
+		stateLock.Lock()
+		statePath = ""
+		stateLock.Unlock()
+		filesLock.Lock()
+		files = originalFiles
+		filesLock.Unlock()
+	}()
+
+	id, err := addStdinDocument([]byte("# From Stdin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("State file should be written: %v", err)
+	}
+	var state persistedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("State file is not valid JSON: %v", err)
+	}
+	if len(state.Files) != 1 || !state.Files[0].Stdin || state.Files[0].Content != "# From Stdin" {
+		t.Fatalf("Expected the document with its content in state, got %+v", state.Files)
+	}
+
+	// Forget the document as a restarted daemon would
+	filesLock.Lock()
+	files = make(map[string]*FileState)
+	filesLock.Unlock()
+
+	if err := restoreState(); err != nil {
+		t.Fatalf("Failed to restore state: %v", err)
+	}
+	filesLock.RLock()
+	fileState, exists := files[id]
+	filesLock.RUnlock()
+	if !exists || !fileState.stdin {
+		t.Fatal("Expected the stdin document to be restored")
+	}
+	fileState.contentLock.RLock()
+	content := string(fileState.htmlContent)
+	fileState.contentLock.RUnlock()
+	if !strings.Contains(content, "From Stdin") {
+		t.Errorf("Expected the restored document to be rendered, got %q", content)
+	}
+}
+
+func TestSaveStateDisabled(t *testing.T) {
+	tmpRuntimeDir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	oldXDG := os.Getenv("XDG_RUNTIME_DIR")
+	if err := os.Setenv("XDG_RUNTIME_DIR", tmpRuntimeDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if oldXDG != "" {
+			_ = os.Setenv("XDG_RUNTIME_DIR", oldXDG)
+		} else {
+			_ = os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+	})
+
+	stateLock.Lock()
+	oldStatePath := statePath
+	statePath = ""
+	stateLock.Unlock()
+	defer func() {
+		stateLock.Lock()
+		statePath = oldStatePath
+		stateLock.Unlock()
+	}()
+
+	saveState()
+
+	path, err := getStatePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("State file should not be written when persistence is disabled")
+	}
+}
+
+func TestStatePort(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	path := filepath.Join(t.TempDir(), "state.json")
+	stateLock.Lock()
+	statePath = path
+	stateLock.Unlock()
+
+	oldAddr := serverAddr
+	serverAddr = "127.0.0.1:16514"
+	defer func() {
+		serverAddr = oldAddr
+		stateLock.Lock()
+		statePath = ""
+		stateLock.Unlock()
+	}()
+
+	if port := loadStatePort(); port != 0 {
+		t.Errorf("Expected no port without a state file, got %d", port)
+	}
+
+	saveState()
+
+	if port := loadStatePort(); port != 16514 {
+		t.Errorf("Expected recorded port 16514, got %d", port)
+	}
+}
+
+func TestGetStatePath(t *testing.T) {
+	stateHome := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", stateHome)
+
+	path, err := getStatePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(stateHome, "lum", "state.json"); path != expected {
+		t.Errorf("Expected %s, got %s", expected, path)
+	}
+	info, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("Expected the state directory to be created: %v", err)
+	}
+	if info.Mode().Perm() != 0o700 {
+		t.Errorf("Expected state directory mode 0700, got %o", info.Mode().Perm())
+	}
+
+	// Relative paths are ignored as the XDG base directory specification requires
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_STATE_HOME", "relative")
+	path, err = getStatePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(home, ".local", "state", "lum", "state.json"); path != expected {
+		t.Errorf("Expected %s, got %s", expected, path)
+	}
+}
+
+func TestRestoreState(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	tmpDir := t.TempDir()
+	var recorded []persistedFile
+	for _, name := range []string{"a.md", "b.md", "c.md"} {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte("# "+name), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		recorded = append(recorded, persistedFile{Path: path, AddedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)})
+	}
+
+	path, err := getStatePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateLock.Lock()
+	statePath = path
+	stateLock.Unlock()
+
+	filesLock.Lock()
+	originalFiles := files
+	filesLock.Unlock()
+
+	// reset forgets the restored files
+	reset := func() {
+		filesLock.Lock()
+		for _, fileState := range files {
+			if fileState.watcher != nil {
+				_ = fileState.watcher.Close()
+			}
+		}
+		files = make(map[string]*FileState)
+		filesLock.Unlock()
+	}
+	defer func() {
+		reset()
+		filesLock.Lock()
+		files = originalFiles
+		filesLock.Unlock()
+		stateLock.Lock()
+		statePath = ""
+		stateLock.Unlock()
+	}()
+
+	t.Run("SavesOnce", func(t *testing.T) {
+		reset()
+		if err := writeStateFile(path, &persistedState{Files: recorded}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Every save replaces the state file, which shows up as a new file in its directory
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = watcher.Close() }()
+		if err := watcher.Add(filepath.Dir(path)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := restoreState(); err != nil {
+			t.Fatalf("Failed to restore state: %v", err)
+		}
+
+		saves := 0
+		timeout := time.After(200 * time.Millisecond)
+	collect:
+		for {
+			select {
+			case event := <-watcher.Events:
+				if event.Name == path && event.Has(fsnotify.Create) {
+					saves++
+				}
+			case <-timeout:
+				break collect
+			}
+		}
+		if saves != 1 {
+			t.Errorf("Expected the state file to be written once after restoring, got %d writes", saves)
+		}
+	})
+}
+
+func TestMergeState(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "state.json")
+
+	servedFile := filepath.Join(tmpDir, "served.md")
+	recordedFile := filepath.Join(tmpDir, "recorded.md")
+	recordedDir := filepath.Join(tmpDir, "docs")
+	addedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	filesLock.Lock()
+	originalFiles := files
+	files = map[string]*FileState{
+		servedFile: {path: servedFile, addedAt: addedAt, sseClients: make(map[chan string]bool)},
+	}
+	filesLock.Unlock()
+	defer func() {
+		filesLock.Lock()
+		files = originalFiles
+		filesLock.Unlock()
+	}()
+
+	recorded := persistedState{
+		Files: []persistedFile{{Path: recordedFile}, {Path: servedFile}},
+		Dirs:  []persistedDir{{Path: recordedDir}},
+	}
+	if err := writeStateFile(path, &recorded); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mergeState(path); err != nil {
+		t.Fatalf("Failed to merge state: %v", err)
+	}
+
+	merged, err := readStateFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged.Files) != 2 || merged.Files[0].Path != recordedFile || merged.Files[1].Path != servedFile {
+		t.Fatalf("Expected the recorded and the served file, got %+v", merged.Files)
+	}
+	if !merged.Files[1].AddedAt.Equal(addedAt) {
+		t.Errorf("Expected the served file's entry to replace the recorded one, got %+v", merged.Files[1])
+	}
+	if len(merged.Dirs) != 1 || merged.Dirs[0].Path != recordedDir {
+		t.Errorf("Expected the recorded directory to be kept, got %+v", merged.Dirs)
+	}
+}
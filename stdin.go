@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	// stdinArg is the command line argument that reads a document from stdin
+	stdinArg = "-"
+	// stdinLabel marks documents read from stdin on pages and in messages
+	stdinLabel = "stdin"
+	// stdinIDPrefix starts the generated IDs that stdin documents are tracked by instead of a path
+	stdinIDPrefix = "stdin-"
+)
+
+// stdinParams are the parameters of the "add_stdin" method
+type stdinParams struct {
+	Content string `json:"content"`
+}
+
+// isStdinID reports whether path is the ID of a document read from stdin rather than a file path
+func isStdinID(path string) bool {
+	return strings.HasPrefix(path, stdinIDPrefix) && !strings.ContainsRune(path, '/')
+}
+
+// newStdinID generates an ID for a document read from stdin
+func newStdinID() (string, error) {
+	random := make([]byte, 4)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate document ID: %w", err)
+	}
+
+	return stdinIDPrefix + hex.EncodeToString(random), nil
+}
+
+// addStdinDocument renders Markdown read from stdin as a new document and returns the ID it is served under
+func addStdinDocument(content []byte) (string, error) {
+	for {
+		id, err := newStdinID()
+		if err != nil {
+			return "", err
+		}
+
+		err = trackStdinDocument(id, content, time.Now())
+		if errors.Is(err, errDocumentExists) {
+			// The random part of the ID clashed with another document, try another one
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		slog.Info("Added document from stdin", "id", id, "bytes", len(content))
+		return id, nil
+	}
+}
+
+// errDocumentExists is returned when a stdin document ID is already in use
+var errDocumentExists = errors.New("document already exists")
+
+// trackStdinDocument tracks and renders a document read from stdin under the given ID
+func trackStdinDocument(id string, content []byte, addedAt time.Time) error {
+	fileState := &FileState{
+		path:       id,
+		addedAt:    addedAt,
+		stdin:      true,
+		source:     content,
+		sseClients: make(map[chan string]bool),
+	}
+
+	filesLock.Lock()
+	if _, exists := files[id]; exists {
+		filesLock.Unlock()
+		return errDocumentExists
+	}
+	files[id] = fileState
+	filesLock.Unlock()
+
+	if err := fileState.render(content, false); err != nil {
+		filesLock.Lock()
+		delete(files, id)
+		filesLock.Unlock()
+		return fmt.Errorf("failed to render document: %w", err)
+	}
+
+	notifyIndexClients("reload")
+	saveState()
+
+	return nil
+}
+
+// addStdinToExistingServer sends a document read from stdin to the running server and returns its URL
+func addStdinToExistingServer(content []byte) (string, error) {
+	var result addResult
+	err := callExistingServer("add_stdin", stdinParams{Content: string(content)}, &result)
+	if errors.Is(err, errLegacyServer) {
+		return "", fmt.Errorf("the running server cannot read documents from stdin, run 'lum --restart' to update it")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return result.URL, nil
+}
+
+// addDocumentsToExistingServer adds the documents to the running server and returns the outcome for every
+// document. An error means that no server could be asked, failures of single documents are in the results.
+func addDocumentsToExistingServer(docs documents) ([]addFileResult, error) {
+	var results []addFileResult
+	if len(docs.paths) > 0 {
+		fileResults, err := addFilesToExistingServer(docs.paths, docs.filter)
+		if err != nil {
+			return nil, err
+		}
+		results = fileResults
+	} else if !daemonExists() {
+		return nil, errNoServer
+	}
+
+	if docs.stdin != nil {
+		url, err := addStdinToExistingServer(docs.stdin)
+		results = append(results, newAddFileResult(stdinLabel, url, err))
+	}
+
+	return results, nil
+}
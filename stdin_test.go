@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsStdinID(t *testing.T) {
+	tests := []struct {
+		path  string
+		stdin bool
+	}{
+		{"stdin-3f9a2c1b", true},
+		{"stdin-", true},
+		{"/tmp/stdin-3f9a2c1b", false},
+		{"docs/stdin-3f9a2c1b", false},
+		{"README.md", false},
+	}
+
+	for _, tt := range tests {
+		if got := isStdinID(tt.path); got != tt.stdin {
+			t.Errorf("isStdinID(%q) = %v, expected %v", tt.path, got, tt.stdin)
+		}
+	}
+
+	id, err := newStdinID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isStdinID(id) || len(id) != len(stdinIDPrefix)+8 {
+		t.Errorf("Expected a generated stdin ID, got %q", id)
+	}
+}
+
+func TestAddStdinDocument(t *testing.T) {
+	id, err := addStdinDocument([]byte("# From Stdin\n\n![logo](logo.png)"))
+	if err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = removeFile(id)
+	})
+
+	t.Run("Rendered", func(t *testing.T) {
+		filesLock.RLock()
+		fileState, exists := files[id]
+		filesLock.RUnlock()
+		if !exists || !fileState.stdin {
+			t.Fatalf("Expected %s to be tracked as a stdin document", id)
+		}
+
+		fileState.contentLock.RLock()
+		content := string(fileState.htmlContent)
+		fileState.contentLock.RUnlock()
+		if !strings.Contains(content, "From Stdin") {
+			t.Errorf("Expected the content to be rendered, got %q", content)
+		}
+	})
+
+	t.Run("Page", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handleIndex(w, httptest.NewRequest("GET", "/?file="+id, nil))
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", w.Code)
+		}
+		if !strings.Contains(w.Body.String(), "<title>"+stdinLabel) {
+			t.Errorf("Expected the page to be titled %q, got %s", stdinLabel, w.Body.String())
+		}
+	})
+
+	t.Run("Index", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handleIndex(w, httptest.NewRequest("GET", "/", nil))
+
+		if !strings.Contains(w.Body.String(), `<span class="file-badge">stdin</span>`) {
+			t.Error("Expected the index page to mark the stdin document")
+		}
+	})
+
+	t.Run("NoStaticAssets", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handleIndex(w, httptest.NewRequest("GET", "/logo.png?file="+id, nil))
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("DuplicateID", func(t *testing.T) {
+		if err := trackStdinDocument(id, []byte("# Other"), time.Now()); err != errDocumentExists {
+			t.Errorf("Expected %v, got %v", errDocumentExists, err)
+		}
+	})
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"errors"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"time"
@@ -24,7 +24,7 @@ func startWatchingFile(filePath string) error {
 	if !exists {
 		filesLock.Unlock()
 		if err := watcher.Close(); err != nil {
-			log.Printf("Failed to close watcher: %v", err)
+			slog.Error("Failed to close watcher", "error", err)
 		}
 		return errors.New("file not in tracked files")
 	}
@@ -37,7 +37,7 @@ func startWatchingFile(filePath string) error {
 	absPath, err := filepath.Abs(filePath)
 	if err != nil {
 		if closeErr := watcher.Close(); closeErr != nil {
-			log.Printf("Failed to close watcher: %v", closeErr)
+			slog.Error("Failed to close watcher", "error", closeErr)
 		}
 		return err
 	}
@@ -46,7 +46,7 @@ func startWatchingFile(filePath string) error {
 
 	if err := watcher.Add(watchDir); err != nil {
 		if closeErr := watcher.Close(); closeErr != nil {
-			log.Printf("Failed to close watcher: %v", closeErr)
+			slog.Error("Failed to close watcher", "error", closeErr)
 		}
 		return err
 	}
@@ -55,7 +55,7 @@ func startWatchingFile(filePath string) error {
 	go func() {
 		defer func() {
 			if err := watcher.Close(); err != nil {
-				log.Printf("Failed to close watcher: %v", err)
+				slog.Error("Failed to close watcher", "error", err)
 			}
 		}()
 
@@ -84,37 +84,42 @@ func startWatchingFile(filePath string) error {
 					}
 					lastReload = now
 
-					log.Printf("File changed: %s (event: %s)", event.Name, event.Op)
-
-					// Retry rendering in case file is temporarily missing during atomic save
-					var err error
-					for range 10 {
-						err = renderMarkdown(filePath)
-						if err == nil {
-							break
-						}
-						// Check if error is "file does not exist" using errors.Is
-						if errors.Is(err, os.ErrNotExist) {
-							time.Sleep(50 * time.Millisecond)
-							continue
-						}
-						break
-					}
-
-					if err != nil {
-						log.Printf("Failed to render markdown: %v", err)
-						continue
-					}
-					notifyClients(filePath, "reload")
+					slog.Debug("File changed", "path", event.Name, "event", event.Op.String())
+					reloadFile(filePath)
 				}
 			case err, ok := <-watcher.Errors:
 				if !ok {
 					return
 				}
-				log.Printf("Watcher error for %s: %v", filePath, err)
+				slog.Error("Watcher error", "path", filePath, "error", err)
 			}
 		}
 	}()
 
 	return nil
 }
+
+// reloadFile renders a changed file again and tells its viewers to reload
+func reloadFile(filePath string) {
+	// Retry rendering in case file is temporarily missing during atomic save
+	var err error
+	for range 10 {
+		err = renderMarkdown(filePath)
+		if err == nil {
+			break
+		}
+		// Check if error is "file does not exist" using errors.Is
+		if errors.Is(err, os.ErrNotExist) {
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		break
+	}
+
+	if err != nil {
+		slog.Error("Failed to render markdown", "path", filePath, "error", err)
+		return
+	}
+	slog.Debug("Rendered file", "path", filePath)
+	notifyClients(filePath, "reload")
+}